@@ -0,0 +1,110 @@
+// Copyright 2021 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package osmfile
+
+// AreaRule decides if a tag makes a closed way an area.
+type AreaRule struct {
+	// Key is the tag key, such as "building".
+	Key string
+	// Values, when not empty, are the only values of the key that make an
+	// area.
+	Values []string
+	// Except are the values of the key that do not make an area.
+	Except []string
+}
+
+func (rule AreaRule) match(value string) bool {
+	if value == "no" {
+		return false
+	}
+	for _, v := range rule.Except {
+		if v == value {
+			return false
+		}
+	}
+	if len(rule.Values) == 0 {
+		return true
+	}
+	for _, v := range rule.Values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultAreaRules are the standard OSM area rules, which are similar to the
+// ones used by the iD editor and osm2pgsql.
+// There are no rules for the highway and barrier keys, other than a few
+// highway features, because those are lines unless tagged with area=yes.
+var DefaultAreaRules = []AreaRule{
+	{Key: "building"},
+	{Key: "building:part"},
+	{Key: "landuse"},
+	{Key: "area:highway"},
+	{Key: "amenity"},
+	{Key: "shop"},
+	{Key: "office"},
+	{Key: "craft"},
+	{Key: "tourism"},
+	{Key: "historic"},
+	{Key: "military"},
+	{Key: "place"},
+	{Key: "golf", Except: []string{"hole"}},
+	{Key: "leisure", Except: []string{"track", "slipway"}},
+	{Key: "natural", Except: []string{
+		"coastline", "cliff", "ridge", "arete", "tree_row",
+	}},
+	{Key: "man_made", Except: []string{
+		"breakwater", "cutline", "embankment", "groyne", "pipeline", "dyke",
+	}},
+	{Key: "aeroway", Except: []string{
+		"runway", "taxiway", "taxilane", "parking_position", "jet_bridge",
+	}},
+	{Key: "power", Except: []string{"line", "minor_line", "cable"}},
+	{Key: "railway", Values: []string{
+		"platform", "station", "turntable", "roundhouse",
+	}},
+	{Key: "waterway", Values: []string{
+		"riverbank", "dock", "boatyard", "dam",
+	}},
+	{Key: "highway", Values: []string{"rest_area", "services"}},
+}
+
+// IsClosed returns true if the way is a closed ring, where the first and
+// last nodes are the same.
+func (w Way) IsClosed() bool {
+	n := w.NumRefs()
+	return n >= 4 && w.RefAt(0) == w.RefAt(n-1)
+}
+
+// IsArea returns true if the way is an area, using the DefaultAreaRules.
+// A way is an area when it's closed and either tagged with area=yes, or has a
+// tag that matches a rule. Ways tagged with area=no are never areas.
+func IsArea(w Way) bool {
+	return IsAreaWithRules(w, DefaultAreaRules)
+}
+
+// IsAreaWithRules returns true if the way is an area, using the provided
+// rules. See IsArea.
+func IsAreaWithRules(w Way, rules []AreaRule) bool {
+	if !w.IsClosed() {
+		return false
+	}
+	if area, ok := w.Tag("area"); ok {
+		if area == "no" {
+			return false
+		}
+		if area == "yes" {
+			return true
+		}
+	}
+	for _, rule := range rules {
+		if value, ok := w.Tag(rule.Key); ok && rule.match(value) {
+			return true
+		}
+	}
+	return false
+}
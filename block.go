@@ -37,6 +37,7 @@ type blockNode struct {
 type Node struct {
 	blockNode
 	block Block
+	index int
 }
 
 // ID ...
@@ -76,6 +77,7 @@ type blockRelation struct {
 type Relation struct {
 	blockRelation
 	block Block
+	index int
 }
 
 // ID ...
@@ -114,12 +116,15 @@ type blockWay struct {
 	send uint32 // position of last string plus one
 	rset uint32 // position of first ref
 	rend uint32 // position of last ref
+	lset uint32 // position of first location
+	lend uint32 // position of last location plus one
 }
 
 // Way ...
 type Way struct {
 	blockWay
 	block Block
+	index int
 }
 
 // ID ...
@@ -137,6 +142,24 @@ func (w Way) RefAt(index int) int64 {
 	return w.block.wayRefs[w.rset:w.rend][index]
 }
 
+// HasLocations returns true if the way carries the locations of its nodes.
+// This is only the case for files with the "LocationsOnWays" feature.
+func (w Way) HasLocations() bool {
+	return w.lend > w.lset && w.lend-w.lset == w.rend-w.rset
+}
+
+// LatAt returns the latitude of the node at index. Only valid when
+// HasLocations is true.
+func (w Way) LatAt(index int) float64 {
+	return w.block.wayLats[w.lset:w.lend][index]
+}
+
+// LonAt returns the longitude of the node at index. Only valid when
+// HasLocations is true.
+func (w Way) LonAt(index int) float64 {
+	return w.block.wayLons[w.lset:w.lend][index]
+}
+
 // NumStrings ...
 func (w Way) NumStrings() int {
 	return int(w.send - w.sset)
@@ -160,19 +183,25 @@ type Block struct {
 	stringsCount int
 	stringsOne   string
 	strings      []string
+	lookup       *stringLookup
 	// nodes
 	nodes       []blockNode
 	nodeStrings []uint32
+	nodeInfos   []blockInfo
 	// ways
 	ways       []blockWay
 	wayStrings []uint32
 	wayRefs    []int64
+	wayLats    []float64
+	wayLons    []float64
+	wayInfos   []blockInfo
 	// relations
 	relations           []blockRelation
 	relationStrings     []uint32
 	relationMemberRoles []uint32
 	relationMemberRefs  []int64
 	relationMemberTypes []byte
+	relationInfos       []blockInfo
 }
 
 // // Weight ...
@@ -216,7 +245,7 @@ func (b Block) NumNodes() int {
 
 // NodeAt ...
 func (b Block) NodeAt(index int) Node {
-	return Node{blockNode: b.nodes[index], block: b}
+	return Node{blockNode: b.nodes[index], block: b, index: index}
 }
 
 // NumWays ...
@@ -226,7 +255,7 @@ func (b Block) NumWays() int {
 
 // WayAt ...
 func (b Block) WayAt(index int) Way {
-	return Way{blockWay: b.ways[index], block: b}
+	return Way{blockWay: b.ways[index], block: b, index: index}
 }
 
 // NumRelations ...
@@ -236,5 +265,5 @@ func (b Block) NumRelations() int {
 
 // RelationAt ...
 func (b Block) RelationAt(index int) Relation {
-	return Relation{blockRelation: b.relations[index], block: b}
+	return Relation{blockRelation: b.relations[index], block: b, index: index}
 }
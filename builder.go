@@ -0,0 +1,111 @@
+// Copyright 2021 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package osmfile
+
+// blockBuilder builds a Block from nodes, ways, and relations that did not
+// come from a PBF file.
+type blockBuilder struct {
+	block Block
+	strs  map[string]uint32
+	count int
+}
+
+func newBlockBuilder() *blockBuilder {
+	bb := &blockBuilder{strs: make(map[string]uint32)}
+	bb.block.granularity = 100
+	bb.block.dateGranularity = 1000
+	bb.block.dataKind = -1
+	bb.str("")
+	return bb
+}
+
+// str returns the string table index for the provided string.
+func (bb *blockBuilder) str(s string) uint32 {
+	idx, ok := bb.strs[s]
+	if !ok {
+		idx = uint32(len(bb.block.strings))
+		bb.strs[s] = idx
+		bb.block.strings = append(bb.block.strings, s)
+	}
+	return idx
+}
+
+func (bb *blockBuilder) added(kind DataKind) {
+	if bb.block.dataKind == -1 {
+		bb.block.dataKind = int(kind)
+	}
+	bb.count++
+}
+
+func (bb *blockBuilder) appendTags(dst []uint32, tags []string) []uint32 {
+	for _, s := range tags {
+		dst = append(dst, bb.str(s))
+	}
+	return dst
+}
+
+// addNode adds a node. The tags are key/value pairs.
+func (bb *blockBuilder) addNode(id int64, lat, lon float64, tags []string,
+	info blockInfo,
+) {
+	b := &bb.block
+	node := blockNode{id: id, lat: lat, lon: lon}
+	node.sset = uint32(len(b.nodeStrings))
+	b.nodeStrings = bb.appendTags(b.nodeStrings, tags)
+	node.send = uint32(len(b.nodeStrings))
+	b.nodes = append(b.nodes, node)
+	b.nodeInfos = append(b.nodeInfos, info)
+	bb.added(DataKindNodes)
+}
+
+// addWay adds a way. The tags are key/value pairs.
+func (bb *blockBuilder) addWay(id int64, refs []int64, tags []string,
+	info blockInfo,
+) {
+	b := &bb.block
+	way := blockWay{id: id}
+	way.sset = uint32(len(b.wayStrings))
+	b.wayStrings = bb.appendTags(b.wayStrings, tags)
+	way.send = uint32(len(b.wayStrings))
+	way.rset = uint32(len(b.wayRefs))
+	b.wayRefs = append(b.wayRefs, refs...)
+	way.rend = uint32(len(b.wayRefs))
+	way.lset = uint32(len(b.wayLats))
+	way.lend = way.lset
+	b.ways = append(b.ways, way)
+	b.wayInfos = append(b.wayInfos, info)
+	bb.added(DataKindWays)
+}
+
+// addRelation adds a relation. The tags are key/value pairs.
+func (bb *blockBuilder) addRelation(id int64, types []byte, refs []int64,
+	roles []string, tags []string, info blockInfo,
+) {
+	b := &bb.block
+	rel := blockRelation{id: id}
+	rel.sset = uint32(len(b.relationStrings))
+	b.relationStrings = bb.appendTags(b.relationStrings, tags)
+	rel.send = uint32(len(b.relationStrings))
+	rel.mset = uint32(len(b.relationMemberRefs))
+	b.relationMemberTypes = append(b.relationMemberTypes, types...)
+	b.relationMemberRefs = append(b.relationMemberRefs, refs...)
+	b.relationMemberRoles = bb.appendTags(b.relationMemberRoles, roles)
+	rel.mend = uint32(len(b.relationMemberRefs))
+	b.relations = append(b.relations, rel)
+	b.relationInfos = append(b.relationInfos, info)
+	bb.added(DataKindRelations)
+}
+
+// finish returns the block and resets the builder.
+func (bb *blockBuilder) finish() Block {
+	block := bb.block
+	if block.dataKind == -1 {
+		block.dataKind = 0
+	}
+	block.stringsCount = len(block.strings)
+	block.lookup = new(stringLookup)
+	*bb = *newBlockBuilder()
+	return block
+}
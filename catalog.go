@@ -0,0 +1,238 @@
+// Copyright 2021 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package osmfile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// DefaultCatalogURL is the url of the Geofabrik extract index.
+const DefaultCatalogURL = "https://download.geofabrik.de/index-v1.json"
+
+// Region is a regional extract in a catalog.
+type Region struct {
+	ID     string // such as "germany" or "us/california"
+	Name   string // such as "Germany"
+	Parent string // id of the parent region, if any
+	// URLs of the files of the extract, by kind. Such as "pbf", "bz2", "shp",
+	// and "updates" for the replication diffs.
+	URLs map[string]string
+	// Geometry is the bounding polygon of the extract.
+	Geometry []Polygon
+}
+
+// PBF returns the url of the OSM protobuf file of the extract.
+func (r *Region) PBF() string {
+	return r.URLs["pbf"]
+}
+
+// Catalog is an index of regional extracts, such as the one hosted by
+// Geofabrik.
+type Catalog struct {
+	Regions []*Region // in index order
+	byID    map[string]*Region
+	byPath  map[string]*Region
+	paths   map[*Region]string
+}
+
+type catalogJSON struct {
+	Features []struct {
+		Properties struct {
+			ID     string            `json:"id"`
+			Name   string            `json:"name"`
+			Parent string            `json:"parent"`
+			URLs   map[string]string `json:"urls"`
+		} `json:"properties"`
+		Geometry *struct {
+			Type        string          `json:"type"`
+			Coordinates json.RawMessage `json:"coordinates"`
+		} `json:"geometry"`
+	} `json:"features"`
+}
+
+// ParseCatalog parses an extract index in the Geofabrik index-v1.json
+// format, which is a GeoJSON FeatureCollection of regions.
+func ParseCatalog(data []byte) (*Catalog, error) {
+	var index catalogJSON
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, err
+	}
+	c := &Catalog{
+		byID:   make(map[string]*Region),
+		byPath: make(map[string]*Region),
+		paths:  make(map[*Region]string),
+	}
+	for _, f := range index.Features {
+		props := f.Properties
+		if props.ID == "" {
+			return nil, errors.New("region without an id")
+		}
+		if _, ok := c.byID[props.ID]; ok {
+			return nil, fmt.Errorf("duplicate region: %s", props.ID)
+		}
+		r := &Region{
+			ID:     props.ID,
+			Name:   props.Name,
+			Parent: props.Parent,
+			URLs:   props.URLs,
+		}
+		if f.Geometry != nil {
+			geom, err := parseRegionGeometry(f.Geometry.Type,
+				f.Geometry.Coordinates)
+			if err != nil {
+				return nil, fmt.Errorf("region %s: %v", r.ID, err)
+			}
+			r.Geometry = geom
+		}
+		c.Regions = append(c.Regions, r)
+		c.byID[r.ID] = r
+	}
+	for _, r := range c.Regions {
+		path, err := c.path(r, len(c.Regions))
+		if err != nil {
+			return nil, err
+		}
+		c.byPath[path] = r
+		c.paths[r] = path
+	}
+	return c, nil
+}
+
+// path returns the path of the region through its parents, such as
+// "europe/germany" or "north-america/us/california".
+func (c *Catalog) path(r *Region, depth int) (string, error) {
+	if r.Parent == "" {
+		return r.ID, nil
+	}
+	parent, ok := c.byID[r.Parent]
+	if !ok {
+		return "", fmt.Errorf("region %s: missing parent %s", r.ID, r.Parent)
+	}
+	if depth == 0 {
+		return "", fmt.Errorf("region %s: parent cycle", r.ID)
+	}
+	ppath, err := c.path(parent, depth-1)
+	if err != nil {
+		return "", err
+	}
+	// ids may already include the parent, such as "us/california"
+	name := r.ID[strings.LastIndexByte(r.ID, '/')+1:]
+	return ppath + "/" + name, nil
+}
+
+// parseRegionGeometry parses the coordinates of a GeoJSON Polygon or
+// MultiPolygon.
+func parseRegionGeometry(typ string, data json.RawMessage) ([]Polygon, error) {
+	var polys [][][][2]float64
+	switch typ {
+	case "Polygon":
+		var poly [][][2]float64
+		if err := json.Unmarshal(data, &poly); err != nil {
+			return nil, err
+		}
+		polys = append(polys, poly)
+	case "MultiPolygon":
+		if err := json.Unmarshal(data, &polys); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("invalid geometry type: %s", typ)
+	}
+	ring := func(coords [][2]float64) []Point {
+		points := make([]Point, len(coords))
+		for i, c := range coords {
+			points[i] = Point{Lat: c[1], Lon: c[0]}
+		}
+		return points
+	}
+	geom := make([]Polygon, 0, len(polys))
+	for _, poly := range polys {
+		if len(poly) == 0 {
+			continue
+		}
+		p := Polygon{Outer: ring(poly[0])}
+		for _, inner := range poly[1:] {
+			p.Inners = append(p.Inners, ring(inner))
+		}
+		geom = append(geom, p)
+	}
+	return geom, nil
+}
+
+// FetchCatalog fetches and parses an extract index, such as
+// DefaultCatalogURL. The options may be nil.
+func FetchCatalog(url string, opts *DownloadOptions) (*Catalog, error) {
+	req, err := opts.newRequest(opts.context(), "GET", url)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := opts.client(0).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, newHTTPError(resp)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCatalog(data)
+}
+
+// Region returns the region for the id, such as "germany", or for the path
+// through its parents, such as "europe/germany".
+func (c *Catalog) Region(id string) (*Region, bool) {
+	if r, ok := c.byID[id]; ok {
+		return r, true
+	}
+	r, ok := c.byPath[strings.Trim(id, "/")]
+	return r, ok
+}
+
+// Path returns the path of the region through its parents, such as
+// "europe/germany".
+func (c *Catalog) Path(r *Region) string {
+	if path, ok := c.paths[r]; ok {
+		return path
+	}
+	return r.ID
+}
+
+// Children returns the regions that have the provided parent id, sorted by
+// id. An empty id returns the top level regions, such as the continents.
+func (c *Catalog) Children(id string) []*Region {
+	var children []*Region
+	for _, r := range c.Regions {
+		if r.Parent == id {
+			children = append(children, r)
+		}
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].ID < children[j].ID
+	})
+	return children
+}
+
+// Download downloads the OSM protobuf file of the region, by id or path, into
+// the provided file path. The options may be nil.
+func (c *Catalog) Download(id string, path string, opts *DownloadOptions) (
+	Downloader, error,
+) {
+	r, ok := c.Region(id)
+	if !ok {
+		return nil, fmt.Errorf("region not found: %s", id)
+	}
+	if r.PBF() == "" {
+		return nil, fmt.Errorf("region %s: no pbf url", r.ID)
+	}
+	return DownloadWithOptions(r.PBF(), path, opts), nil
+}
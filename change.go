@@ -0,0 +1,262 @@
+// Copyright 2021 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package osmfile
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// ChangeAction is the action of an OsmChange record.
+type ChangeAction int
+
+// ChangeAction options
+const (
+	ChangeCreate ChangeAction = 0
+	ChangeModify ChangeAction = 1
+	ChangeDelete ChangeAction = 2
+)
+
+func (a ChangeAction) String() string {
+	switch a {
+	case ChangeCreate:
+		return "create"
+	case ChangeModify:
+		return "modify"
+	case ChangeDelete:
+		return "delete"
+	default:
+		return "unknown"
+	}
+}
+
+// Change is a single record of an OsmChange (.osc) file. Only one of the
+// Node, Way, or Relation fields is valid, depending on the Kind.
+type Change struct {
+	Action   ChangeAction
+	Kind     DataKind
+	Node     Node
+	Way      Way
+	Relation Relation
+}
+
+// ID returns the id of the changed node, way, or relation.
+func (c Change) ID() int64 {
+	switch c.Kind {
+	case DataKindNodes:
+		return c.Node.ID()
+	case DataKindWays:
+		return c.Way.ID()
+	default:
+		return c.Relation.ID()
+	}
+}
+
+// ReadChanges reads all changes from an OsmChange file. Files compressed
+// using gzip (.osc.gz) or bzip2 are detected and decompressed.
+// The changes are returned in file order.
+func ReadChanges(r io.Reader) ([]Change, error) {
+	dr, err := decompress(r)
+	if err != nil {
+		return nil, err
+	}
+	type record struct {
+		action ChangeAction
+		kind   DataKind
+		index  int
+	}
+	var records []record
+	var counts [3]int
+	dec := xml.NewDecoder(dr)
+	bb := newBlockBuilder()
+	action := ChangeAction(-1)
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			switch tok.Name.Local {
+			case "create":
+				action = ChangeCreate
+			case "modify":
+				action = ChangeModify
+			case "delete":
+				action = ChangeDelete
+			case "node", "way", "relation":
+				if action == -1 {
+					return nil, fmt.Errorf("%s outside of an action",
+						tok.Name.Local)
+				}
+				el := new(xmlElement)
+				if err := dec.DecodeElement(el, &tok); err != nil {
+					return nil, err
+				}
+				if action == ChangeDelete {
+					el.Visible = "false"
+				}
+				if err := el.add(bb); err != nil {
+					return nil, err
+				}
+				kind := el.kind()
+				records = append(records, record{action, kind, counts[kind]})
+				counts[kind]++
+			}
+		case xml.EndElement:
+			switch tok.Name.Local {
+			case "create", "modify", "delete":
+				action = -1
+			}
+		}
+	}
+	block := bb.finish()
+	changes := make([]Change, len(records))
+	for i, rec := range records {
+		changes[i].Action = rec.action
+		changes[i].Kind = rec.kind
+		switch rec.kind {
+		case DataKindNodes:
+			changes[i].Node = block.NodeAt(rec.index)
+		case DataKindWays:
+			changes[i].Way = block.WayAt(rec.index)
+		default:
+			changes[i].Relation = block.RelationAt(rec.index)
+		}
+	}
+	return changes, nil
+}
+
+func (c Change) write(w *Writer) error {
+	switch c.Kind {
+	case DataKindNodes:
+		return w.WriteNode(c.Node)
+	case DataKindWays:
+		return w.WriteWay(c.Way)
+	default:
+		return w.WriteRelation(c.Relation)
+	}
+}
+
+// ApplyChanges applies the changes to the nodes, ways, and relations read
+// from src, and writes the results to w. The source must be sorted by type
+// then id, and the results will be too. When an element has multiple
+// changes, the last one wins. The header is not written, so it may be
+// written beforehand with updated replication fields.
+//
+// A BlockReader source is read with Everything|Metadata, so that unchanged
+// elements keep their metadata. A ParallelBlockReader source should be
+// created with NewParallelBlockReaderWhat(r, workers, Everything|Metadata).
+func ApplyChanges(w *Writer, src BlockSource, changes []Change) error {
+	changes = append([]Change(nil), changes...)
+	sort.SliceStable(changes, func(i, j int) bool {
+		if changes[i].Kind != changes[j].Kind {
+			return changes[i].Kind < changes[j].Kind
+		}
+		return changes[i].ID() < changes[j].ID()
+	})
+	// only keep the last change of each element
+	var n int
+	for i := range changes {
+		if n > 0 && changes[n-1].Kind == changes[i].Kind &&
+			changes[n-1].ID() == changes[i].ID() {
+			changes[n-1] = changes[i]
+		} else {
+			changes[n] = changes[i]
+			n++
+		}
+	}
+	changes = changes[:n]
+
+	var i int
+	lastKind, lastID := DataKind(-1), int64(0)
+	// apply writes the element from the source, or the change that replaces
+	// it, along with all changes for elements that come before it.
+	apply := func(kind DataKind, id int64, write func() error) error {
+		if kind < lastKind || (kind == lastKind && id <= lastID) {
+			return errors.New("source not sorted by type then id")
+		}
+		lastKind, lastID = kind, id
+		for ; i < len(changes); i++ {
+			c := changes[i]
+			if c.Kind > kind || (c.Kind == kind && c.ID() >= id) {
+				break
+			}
+			if c.Action != ChangeDelete {
+				if err := c.write(w); err != nil {
+					return err
+				}
+			}
+		}
+		if i < len(changes) && changes[i].Kind == kind &&
+			changes[i].ID() == id {
+			c := changes[i]
+			i++
+			if c.Action == ChangeDelete {
+				return nil
+			}
+			return c.write(w)
+		}
+		return write()
+	}
+	read := src.ReadBlock
+	if br, ok := src.(interface {
+		ReadBlockWhat(what What) (int, Block, error)
+	}); ok {
+		read = func() (int, Block, error) {
+			return br.ReadBlockWhat(Everything | Metadata)
+		}
+	}
+	for {
+		_, block, err := read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		for j := 0; j < block.NumNodes(); j++ {
+			n := block.NodeAt(j)
+			err := apply(DataKindNodes, n.ID(), func() error {
+				return w.WriteNode(n)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		for j := 0; j < block.NumWays(); j++ {
+			way := block.WayAt(j)
+			err := apply(DataKindWays, way.ID(), func() error {
+				return w.WriteWay(way)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		for j := 0; j < block.NumRelations(); j++ {
+			rel := block.RelationAt(j)
+			err := apply(DataKindRelations, rel.ID(), func() error {
+				return w.WriteRelation(rel)
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for ; i < len(changes); i++ {
+		if changes[i].Action != ChangeDelete {
+			if err := changes[i].write(w); err != nil {
+				return err
+			}
+		}
+	}
+	return w.Flush()
+}
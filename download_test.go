@@ -0,0 +1,369 @@
+// Copyright 2021 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package osmfile
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testServer serves a file, and its md5 checksum with the ".md5" suffix.
+type testServer struct {
+	*httptest.Server
+	data []byte
+	sum  string
+
+	mu       sync.Mutex
+	requests int // number of requests for the file
+	fail     int // number of file requests that fail, from now on
+	failCode int // status code of the failed requests
+}
+
+func newTestServer(t *testing.T, size int) *testServer {
+	ts := &testServer{data: make([]byte, size)}
+	rand.New(rand.NewSource(int64(size))).Read(ts.data)
+	sum := md5.Sum(ts.data)
+	ts.sum = hex.EncodeToString(sum[:])
+	ts.Server = httptest.NewServer(http.HandlerFunc(ts.serve))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func (ts *testServer) serve(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, ".md5") {
+		w.Write([]byte(ts.sum + "  file.osm.pbf\n"))
+		return
+	}
+	ts.mu.Lock()
+	ts.requests++
+	fail := r.Method == "GET" && ts.fail > 0
+	if fail {
+		ts.fail--
+	}
+	ts.mu.Unlock()
+	if fail {
+		w.WriteHeader(ts.failCode)
+		return
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(ts.data))
+}
+
+func (ts *testServer) url() string {
+	return ts.URL + "/file.osm.pbf"
+}
+
+func testDownload(t *testing.T, ts *testServer, path string,
+	opts *DownloadOptions,
+) error {
+	t.Helper()
+	dl := DownloadWithOptions(ts.url(), path, opts)
+	data, err := ioutil.ReadAll(dl.Reader())
+	if err := dl.Error(); err != nil {
+		return err
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, ts.data) {
+		t.Fatal("the reader returned the wrong data")
+	}
+	data, err = ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, ts.data) {
+		t.Fatal("the file has the wrong data")
+	}
+	return nil
+}
+
+func TestDownload(t *testing.T) {
+	ts := newTestServer(t, 100000)
+	path := filepath.Join(t.TempDir(), "file.osm.pbf")
+	err := testDownload(t, ts, path, &DownloadOptions{Checksum: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDownloadResume(t *testing.T) {
+	ts := newTestServer(t, 100000)
+	path := filepath.Join(t.TempDir(), "file.osm.pbf")
+	if err := ioutil.WriteFile(path, ts.data[:30000], 0666); err != nil {
+		t.Fatal(err)
+	}
+	err := testDownload(t, ts, path, &DownloadOptions{Checksum: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a complete file is not downloaded again
+	ts.requests = 0
+	err = testDownload(t, ts, path, &DownloadOptions{Checksum: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts.requests != 1 {
+		t.Fatalf("expected only a HEAD request, got %d requests",
+			ts.requests)
+	}
+}
+
+func TestDownloadChecksumMismatch(t *testing.T) {
+	ts := newTestServer(t, 100000)
+	ts.sum = strings.Repeat("0", 32)
+	path := filepath.Join(t.TempDir(), "file.osm.pbf")
+	dl := DownloadWithOptions(ts.url(), path, &DownloadOptions{
+		Checksum: true,
+	})
+	var cerr *ChecksumError
+	if err := dl.Error(); !errors.As(err, &cerr) {
+		t.Fatalf("expected a checksum error, got %v", err)
+	}
+	if cerr.Expected != ts.sum || cerr.Path != path {
+		t.Fatalf("unexpected checksum error: %v", cerr)
+	}
+}
+
+func TestDownloadChecksumRestart(t *testing.T) {
+	ts := newTestServer(t, 100000)
+	path := filepath.Join(t.TempDir(), "file.osm.pbf")
+	// the start of a previous download is corrupt
+	bad := append([]byte(nil), ts.data[:30000]...)
+	bad[100] ^= 0xFF
+	for _, full := range []bool{false, true} {
+		data := bad
+		if full {
+			data = append(bad, ts.data[len(bad):]...)
+		}
+		if err := ioutil.WriteFile(path, data, 0666); err != nil {
+			t.Fatal(err)
+		}
+		dl := DownloadWithOptions(ts.url(), path, &DownloadOptions{
+			Checksum: true,
+		})
+		// A reader that already read the bad data fails, because it can't be
+		// taken back. Otherwise it reads the new data.
+		rd := dl.Reader()
+		rdata, err := ioutil.ReadAll(rd)
+		if err != errRestarted && (err != nil || !bytes.Equal(rdata, ts.data)) {
+			t.Fatalf("expected errRestarted or the new data, got %v", err)
+		}
+		rd.Close()
+		if err := dl.Error(); err != nil {
+			t.Fatal(err)
+		}
+		fdata, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(fdata, ts.data) {
+			t.Fatal("the file has the wrong data")
+		}
+	}
+}
+
+func TestDownloadStop(t *testing.T) {
+	ts := newTestServer(t, 100000)
+	path := filepath.Join(t.TempDir(), "file.osm.pbf")
+	dl := DownloadWithOptions(ts.url(), path, &DownloadOptions{
+		RateLimit: 10000,
+	})
+	dl.Stop()
+	if err := dl.Error(); err == nil {
+		t.Fatal("expected an error")
+	}
+	if _, err := os.Stat(path); err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+}
+
+func TestDownloadSegments(t *testing.T) {
+	ts := newTestServer(t, segmentSize*2+1000)
+	mirror := newTestServer(t, segmentSize*2+1000)
+	path := filepath.Join(t.TempDir(), "file.osm.pbf")
+	err := testDownload(t, ts, path, &DownloadOptions{
+		Checksum: true,
+		Segments: 3,
+		Mirrors:  []string{mirror.url()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mirror.requests == 0 {
+		t.Fatal("expected requests to the mirror")
+	}
+	if _, err := os.Stat(segmentsPath(path)); !os.IsNotExist(err) {
+		t.Fatal("expected the segments file to be removed")
+	}
+}
+
+func TestDownloadSegmentsResume(t *testing.T) {
+	ts := newTestServer(t, segmentSize*2+1000)
+	path := filepath.Join(t.TempDir(), "file.osm.pbf")
+	// an interrupted download that only finished the second segment
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd := &segmentedDownload{path: path, size: int64(len(ts.data)),
+		dl: newDlfut(context.Background()), f: f,
+		segs: newSegments(int64(len(ts.data)))}
+	sd.segs[1].done = segmentSize
+	if _, err := f.WriteAt(ts.data[segmentSize:segmentSize*2],
+		segmentSize); err != nil {
+		t.Fatal(err)
+	}
+	if err := sd.saveSegments(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(int64(len(ts.data))); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	// the sparse file must not be resumed as a single stream
+	err = testDownload(t, ts, path, &DownloadOptions{Checksum: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(segmentsPath(path)); !os.IsNotExist(err) {
+		t.Fatal("expected the segments file to be removed")
+	}
+}
+
+func TestDownloadSegmentsFailover(t *testing.T) {
+	ts := newTestServer(t, segmentSize+1000)
+	mirror := newTestServer(t, segmentSize+1000)
+	// the mirror fails every request, so its segments are retried on ts
+	mirror.fail = 100
+	mirror.failCode = 500
+	path := filepath.Join(t.TempDir(), "file.osm.pbf")
+	err := testDownload(t, ts, path, &DownloadOptions{
+		Segments: 2,
+		Mirrors:  []string{mirror.url()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDownloadRetry(t *testing.T) {
+	ts := newTestServer(t, 100000)
+	ts.fail = 2
+	ts.failCode = 503
+	path := filepath.Join(t.TempDir(), "file.osm.pbf")
+	policy := &RetryPolicy{InitialBackoff: time.Millisecond}
+	dl := DownloadWithOptions(ts.url(), path, &DownloadOptions{
+		Checksum: true,
+		Retry:    policy,
+	})
+	if err := dl.Error(); err != nil {
+		t.Fatal(err)
+	}
+	if retries := dl.Status().Retries; retries != 2 {
+		t.Fatalf("expected 2 retries, got %d", retries)
+	}
+
+	// errors that won't go away are not retried
+	ts.fail = 2
+	ts.failCode = 404
+	path = filepath.Join(t.TempDir(), "file.osm.pbf")
+	dl = DownloadWithOptions(ts.url(), path, &DownloadOptions{Retry: policy})
+	if err := dl.Error(); err == nil || dl.Status().Retries != 0 {
+		t.Fatalf("expected an error without retries, got %v", err)
+	}
+
+	// the attempts are limited by default
+	ts.fail = 100
+	ts.failCode = 500
+	dl = DownloadWithOptions(ts.url(), path, &DownloadOptions{Retry: policy})
+	if err := dl.Error(); err == nil || dl.Status().Retries != 4 {
+		t.Fatalf("expected an error after 4 retries, got %v, %d", err,
+			dl.Status().Retries)
+	}
+}
+
+func TestDownloadRetryStall(t *testing.T) {
+	var mu sync.Mutex
+	var stalls int
+	data := make([]byte, 10000)
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			stall := r.Method == "GET" && stalls == 0
+			if stall {
+				stalls++
+			}
+			mu.Unlock()
+			if stall {
+				// send some of the data, then stop
+				w.Header().Set("Content-Range",
+					fmt.Sprintf("bytes 0-%d/%d", len(data)-1, len(data)))
+				w.WriteHeader(206)
+				w.Write(data[:1000])
+				w.(http.Flusher).Flush()
+				<-r.Context().Done()
+				return
+			}
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+		}))
+	defer srv.Close()
+	path := filepath.Join(t.TempDir(), "file.osm.pbf")
+	dl := DownloadWithOptions(srv.URL+"/file.osm.pbf", path,
+		&DownloadOptions{Retry: &RetryPolicy{
+			InitialBackoff: time.Millisecond,
+			StallTimeout:   time.Millisecond * 100,
+		}})
+	if err := dl.Error(); err != nil {
+		t.Fatal(err)
+	}
+	if retries := dl.Status().Retries; retries != 1 {
+		t.Fatalf("expected 1 retry, got %d", retries)
+	}
+	got, err := ioutil.ReadFile(path)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("the file has the wrong data: %v", err)
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	client := &http.Client{Timeout: time.Second}
+	_, refused := client.Get("http://127.0.0.1:1/")
+	_, scheme := client.Get("foo://bar")
+	for i, c := range []struct {
+		err  error
+		want bool
+	}{
+		{errStalled, true},
+		{fmt.Errorf("segment 1: %w", errAttemptTimeout), true},
+		{io.ErrUnexpectedEOF, true},
+		{&httpError{code: 503}, true},
+		{&httpError{code: 429}, true},
+		{&httpError{code: 404}, false},
+		{refused, true},
+		{scheme, false},
+		{errCorrupt, false},
+		{&ChecksumError{}, false},
+		{os.ErrPermission, false},
+	} {
+		if retryable(c.err) != c.want {
+			t.Fatalf("%d: %v: expected %v", i, c.err, c.want)
+		}
+	}
+}
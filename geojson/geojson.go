@@ -0,0 +1,263 @@
+// Copyright 2021 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+// Package geojson converts OSM nodes, ways, and relations into GeoJSON
+// features.
+package geojson
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+
+	"github.com/tidwall/osmfile"
+)
+
+// ErrDegenerateWay is returned by FromWay for a way with fewer than two nodes,
+// such as one that was clipped in an extract, which is not a valid
+// LineString.
+var ErrDegenerateWay = errors.New("degenerate way")
+
+// Feature is a GeoJSON Feature.
+type Feature struct {
+	Type       string            `json:"type"`
+	ID         string            `json:"id,omitempty"`
+	Geometry   Geometry          `json:"geometry"`
+	Properties map[string]string `json:"properties"`
+}
+
+// Geometry is a GeoJSON Geometry.
+type Geometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+type tagger interface {
+	ForEachTag(iter func(key, value string) bool)
+}
+
+func properties(t tagger) map[string]string {
+	props := make(map[string]string)
+	t.ForEachTag(func(key, value string) bool {
+		props[key] = value
+		return true
+	})
+	return props
+}
+
+func position(p osmfile.Point) [2]float64 {
+	return [2]float64{p.Lon, p.Lat}
+}
+
+func positions(points []osmfile.Point) [][2]float64 {
+	coords := make([][2]float64, len(points))
+	for i, p := range points {
+		coords[i] = position(p)
+	}
+	return coords
+}
+
+// ringArea returns the signed area of the ring, which is positive for
+// counter-clockwise rings.
+func ringArea(ring [][2]float64) float64 {
+	var area float64
+	for i := 0; i < len(ring)-1; i++ {
+		area += ring[i][0]*ring[i+1][1] - ring[i+1][0]*ring[i][1]
+	}
+	return area / 2
+}
+
+func polygon(p osmfile.Polygon) [][][2]float64 {
+	rings := [][][2]float64{positions(p.Outer)}
+	for _, inner := range p.Inners {
+		rings = append(rings, positions(inner))
+	}
+	return rings
+}
+
+// FromNode returns a Point feature for the node.
+func FromNode(n osmfile.Node) Feature {
+	return Feature{
+		Type: "Feature",
+		ID:   "node/" + strconv.FormatInt(n.ID(), 10),
+		Geometry: Geometry{
+			Type:        "Point",
+			Coordinates: [2]float64{n.Lon(), n.Lat()},
+		},
+		Properties: properties(n),
+	}
+}
+
+// FromWay returns a Polygon feature for ways that are areas, according to
+// osmfile.IsArea, and a LineString feature for all other ways. The node
+// locations are resolved using the store. Returns ErrDegenerateWay for ways
+// with fewer than two nodes.
+func FromWay(w osmfile.Way, store osmfile.NodeLocationStore) (Feature, error) {
+	if w.NumRefs() < 2 {
+		return Feature{}, ErrDegenerateWay
+	}
+	points, err := w.Coordinates(store)
+	if err != nil {
+		return Feature{}, err
+	}
+	f := Feature{
+		Type:       "Feature",
+		ID:         "way/" + strconv.FormatInt(w.ID(), 10),
+		Properties: properties(w),
+	}
+	if osmfile.IsArea(w) {
+		outer := positions(points)
+		if ringArea(outer) < 0 {
+			// outer rings are counter-clockwise
+			for i, j := 0, len(outer)-1; i < j; i, j = i+1, j-1 {
+				outer[i], outer[j] = outer[j], outer[i]
+			}
+		}
+		f.Geometry = Geometry{
+			Type:        "Polygon",
+			Coordinates: [][][2]float64{outer},
+		}
+	} else {
+		f.Geometry = Geometry{
+			Type:        "LineString",
+			Coordinates: positions(points),
+		}
+	}
+	return f, nil
+}
+
+// FromMultipolygon returns a MultiPolygon feature for a multipolygon relation
+// that was assembled into polygons using an osmfile.Assembler or
+// osmfile.AssembleMultipolygon.
+func FromMultipolygon(r osmfile.Relation, polys []osmfile.Polygon) Feature {
+	coords := make([][][][2]float64, len(polys))
+	for i, p := range polys {
+		coords[i] = polygon(p)
+	}
+	return Feature{
+		Type: "Feature",
+		ID:   "relation/" + strconv.FormatInt(r.ID(), 10),
+		Geometry: Geometry{
+			Type:        "MultiPolygon",
+			Coordinates: coords,
+		},
+		Properties: properties(r),
+	}
+}
+
+// Writer writes a stream of features, either as a FeatureCollection or as
+// newline-delimited GeoJSON.
+type Writer struct {
+	// Skipped, when not nil, is called by WriteBlock for each way that was
+	// skipped because the location of one of its nodes is missing, such as
+	// a way in an extract that crosses the border, or because it has fewer
+	// than two nodes.
+	Skipped func(way osmfile.Way, err error)
+
+	w          *bufio.Writer
+	collection bool
+	count      int
+	closed     bool
+}
+
+// NewCollectionWriter returns a writer that writes the features as a single
+// FeatureCollection. The writer must be closed to complete the collection.
+func NewCollectionWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w), collection: true}
+}
+
+// NewLineWriter returns a writer that writes each feature on its own line,
+// also known as newline-delimited GeoJSON. The writer must be closed to flush
+// any buffered features.
+func NewLineWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// WriteFeature writes a feature.
+func (w *Writer) WriteFeature(f Feature) error {
+	if w.closed {
+		return errors.New("writer closed")
+	}
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	if w.collection {
+		if w.count == 0 {
+			w.w.WriteString(`{"type":"FeatureCollection","features":[`)
+		} else {
+			w.w.WriteByte(',')
+		}
+		w.w.WriteByte('\n')
+	}
+	_, err = w.w.Write(data)
+	if !w.collection {
+		w.w.WriteByte('\n')
+	}
+	w.count++
+	return err
+}
+
+// WriteBlock writes a feature for each tagged node and each way in a block
+// that was read by an osmfile.BlockReader. The node locations of ways are
+// resolved using the store, and ways with missing node locations or fewer than
+// two nodes are skipped.
+// The filter, when not nil, decides which features are written. Relations
+// are not written, use FromMultipolygon instead.
+func (w *Writer) WriteBlock(b osmfile.Block, store osmfile.NodeLocationStore,
+	filter func(f Feature) bool,
+) error {
+	for i := 0; i < b.NumNodes(); i++ {
+		n := b.NodeAt(i)
+		if n.TagCount() == 0 {
+			continue
+		}
+		f := FromNode(n)
+		if filter == nil || filter(f) {
+			if err := w.WriteFeature(f); err != nil {
+				return err
+			}
+		}
+	}
+	for i := 0; i < b.NumWays(); i++ {
+		way := b.WayAt(i)
+		f, err := FromWay(way, store)
+		if err != nil {
+			if !errors.Is(err, osmfile.ErrNodeNotFound) &&
+				!errors.Is(err, ErrDegenerateWay) {
+				return err
+			}
+			if w.Skipped != nil {
+				w.Skipped(way, err)
+			}
+			continue
+		}
+		if filter == nil || filter(f) {
+			if err := w.WriteFeature(f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close completes the output and flushes all buffered data. It does not
+// close the underlying writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return errors.New("writer closed")
+	}
+	w.closed = true
+	if w.collection {
+		if w.count == 0 {
+			w.w.WriteString(`{"type":"FeatureCollection","features":[]}`)
+		} else {
+			w.w.WriteString("\n]}")
+		}
+		w.w.WriteByte('\n')
+	}
+	return w.w.Flush()
+}
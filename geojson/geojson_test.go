@@ -0,0 +1,84 @@
+// Copyright 2021 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package geojson
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/tidwall/osmfile"
+)
+
+type testWay struct {
+	id   int64
+	refs []int64
+}
+
+func (w testWay) ID() int64                 { return w.id }
+func (w testWay) NumRefs() int              { return len(w.refs) }
+func (w testWay) RefAt(index int) int64     { return w.refs[index] }
+func (w testWay) NumStrings() int           { return 2 }
+func (w testWay) StringAt(index int) string { return []string{"a", "b"}[index] }
+
+// testBlock returns a block with ways that have zero, one, two, and three
+// nodes. Node 4 is missing from the store.
+func testBlock(t *testing.T) osmfile.Block {
+	var buf bytes.Buffer
+	w := osmfile.NewWriter(&buf)
+	for i, refs := range [][]int64{nil, {1}, {1, 2}, {1, 2, 4}} {
+		if err := w.WriteWay(testWay{int64(i + 1), refs}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	_, block, err := osmfile.NewBlockReader(&buf).ReadBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+func TestWriteBlockSkipped(t *testing.T) {
+	block := testBlock(t)
+	store := osmfile.NewSparseLocationStore()
+	store.Set(1, 1, 2)
+	store.Set(2, 3, 4)
+	var buf bytes.Buffer
+	w := NewLineWriter(&buf)
+	skipped := make(map[int64]error)
+	w.Skipped = func(way osmfile.Way, err error) {
+		skipped[way.ID()] = err
+	}
+	if err := w.WriteBlock(block, store, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for id, want := range map[int64]error{
+		1: ErrDegenerateWay, 2: ErrDegenerateWay,
+		4: osmfile.ErrNodeNotFound,
+	} {
+		if !errors.Is(skipped[id], want) {
+			t.Fatalf("way %d: expected %v, got %v", id, want, skipped[id])
+		}
+	}
+	if len(skipped) != 3 {
+		t.Fatalf("expected 3 skipped ways, got %d", len(skipped))
+	}
+	var f Feature
+	if err := json.Unmarshal(buf.Bytes(), &f); err != nil {
+		t.Fatal(err)
+	}
+	coords, _ := f.Geometry.Coordinates.([]interface{})
+	if f.ID != "way/3" || f.Geometry.Type != "LineString" ||
+		len(coords) != 2 {
+		t.Fatalf("unexpected feature: %s", buf.Bytes())
+	}
+}
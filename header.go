@@ -0,0 +1,138 @@
+// Copyright 2021 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package osmfile
+
+import (
+	"errors"
+	"time"
+
+	"github.com/tidwall/osmfile/internal/pbf"
+)
+
+// ErrNoHeader is returned by BlockReader.Header when the file does not start
+// with an OSMHeader block.
+var ErrNoHeader = errors.New("no header")
+
+// BBox is a bounding box in degrees.
+type BBox struct {
+	MinLat float64
+	MinLon float64
+	MaxLat float64
+	MaxLon float64
+}
+
+// Header is the OSMHeader block of an OSM protobuf file.
+type Header struct {
+	// BBox is the bounding box of the file, or nil if not provided.
+	BBox *BBox
+	// RequiredFeatures are the features that a reader must understand in
+	// order to correctly read the file. Such as "OsmSchema-V0.6",
+	// "DenseNodes", and "HistoricalInformation".
+	RequiredFeatures []string
+	// OptionalFeatures are features that a reader may use, but are not
+	// required. Such as "Sort.Type_then_ID" and "LocationsOnWays".
+	OptionalFeatures []string
+	// WritingProgram is the program that wrote the file.
+	WritingProgram string
+	// Source is the origin of the data, such as the bbox generator.
+	Source string
+	// ReplicationTimestamp is the time of the last replication diff that
+	// was applied to the file, or the zero time if not provided.
+	ReplicationTimestamp time.Time
+	// ReplicationSequenceNumber is the sequence number of the last
+	// replication diff that was applied to the file.
+	ReplicationSequenceNumber int64
+	// ReplicationBaseURL is the base url of the replication diffs.
+	ReplicationBaseURL string
+}
+
+// HasFeature returns true if the provided feature is in either the required
+// or optional features.
+func (h Header) HasFeature(feature string) bool {
+	for _, f := range h.RequiredFeatures {
+		if f == feature {
+			return true
+		}
+	}
+	for _, f := range h.OptionalFeatures {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+func procHeader(data []byte) (Header, error) {
+	//
+	// message HeaderBlock {
+	// 	optional HeaderBBox bbox = 1;
+	// 	repeated string required_features = 4;
+	// 	repeated string optional_features = 5;
+	// 	optional string writingprogram = 16;
+	// 	optional string source = 17;
+	// 	optional int64 osmosis_replication_timestamp = 32;
+	// 	optional int64 osmosis_replication_sequence_number = 33;
+	// 	optional string osmosis_replication_base_url = 34;
+	// }
+	//
+	var hdr Header
+	err := pbf.ForEachField(data, func(f pbf.Field) error {
+		switch f.Num() {
+		case 1:
+			bbox, err := procHeaderBBox(f.Data())
+			if err != nil {
+				return err
+			}
+			hdr.BBox = &bbox
+		case 4:
+			hdr.RequiredFeatures = append(hdr.RequiredFeatures,
+				string(f.Data()))
+		case 5:
+			hdr.OptionalFeatures = append(hdr.OptionalFeatures,
+				string(f.Data()))
+		case 16:
+			hdr.WritingProgram = string(f.Data())
+		case 17:
+			hdr.Source = string(f.Data())
+		case 32:
+			hdr.ReplicationTimestamp = time.Unix(int64(f.Uint64()), 0).UTC()
+		case 33:
+			hdr.ReplicationSequenceNumber = int64(f.Uint64())
+		case 34:
+			hdr.ReplicationBaseURL = string(f.Data())
+		}
+		return nil
+	})
+	if err != nil {
+		return Header{}, err
+	}
+	return hdr, nil
+}
+
+func procHeaderBBox(data []byte) (BBox, error) {
+	//
+	// message HeaderBBox {
+	// 	required sint64 left = 1;
+	// 	required sint64 right = 2;
+	// 	required sint64 top = 3;
+	// 	required sint64 bottom = 4;
+	// }
+	//
+	var bbox BBox
+	err := pbf.ForEachField(data, func(f pbf.Field) error {
+		switch f.Num() {
+		case 1:
+			bbox.MinLon = .000000001 * float64(f.Int64())
+		case 2:
+			bbox.MaxLon = .000000001 * float64(f.Int64())
+		case 3:
+			bbox.MaxLat = .000000001 * float64(f.Int64())
+		case 4:
+			bbox.MinLat = .000000001 * float64(f.Int64())
+		}
+		return nil
+	})
+	return bbox, err
+}
@@ -0,0 +1,246 @@
+// Copyright 2021 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package osmfile
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+
+	"github.com/tidwall/osmfile/internal/pbf"
+)
+
+const blobIndexMagic = "OSMFIDX1"
+
+// BlobIndexEntry is the location and contents of a single OSMData block.
+type BlobIndexEntry struct {
+	Offset int64    // offset of the block in the file
+	Size   int64    // size of the block, including its header
+	Kind   DataKind // kind of data in the block
+	MinID  int64    // smallest node, way, or relation id in the block
+	MaxID  int64    // largest node, way, or relation id in the block
+}
+
+// BlobIndex is an index of all OSMData blocks in an OSM protobuf file. It
+// allows for jumping directly to a block by seeking the file to the entry
+// Offset and then using a new BlockReader.
+type BlobIndex struct {
+	Entries []BlobIndexEntry
+}
+
+// BuildBlobIndex scans an OSM protobuf file and returns an index of the
+// OSMData blocks. Every OSMData block is read and inflated to find its kind
+// and ids, so the whole file is read, though only the ids are decoded. Other
+// blocks, such as the OSMHeader, are skipped. Use BuildBlobIndexHeaders for a
+// scan that seeks past the data of every block.
+func BuildBlobIndex(r io.Reader) (*BlobIndex, error) {
+	rr := newRawBlockReader(r)
+	idx := new(BlobIndex)
+	for {
+		offset := rr.pos
+		btype, bsize, err := rr.readBlobHeader()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		if btype != "OSMData" {
+			if err := rr.skipBlobData(bsize); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		bdata, err := rr.readBlobData(bsize)
+		if err != nil {
+			return nil, err
+		}
+		data, err := inflate(bdata)
+		if err != nil {
+			return nil, err
+		}
+		entry := BlobIndexEntry{Offset: offset, Size: rr.pos - offset}
+		if err := procBlockIDs(data, &entry); err != nil {
+			return nil, err
+		}
+		idx.Entries = append(idx.Entries, entry)
+	}
+	return idx, nil
+}
+
+// BuildBlobIndexHeaders scans an OSM protobuf file and returns an index of
+// the OSMData blocks, by only reading the blob headers. The data of the
+// blocks is skipped, which uses Seek when the reader is an io.Seeker, so only
+// a small part of the file is read. The kinds and ids of the blocks are not
+// known, so the Kind of each entry is -1 and the ids are zero, which means
+// the index can't be used with First and Search.
+func BuildBlobIndexHeaders(r io.Reader) (*BlobIndex, error) {
+	rr := newRawBlockReader(r)
+	idx := new(BlobIndex)
+	for {
+		offset := rr.pos
+		btype, bsize, err := rr.readBlobHeader()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		if err := rr.skipBlobData(bsize); err != nil {
+			return nil, err
+		}
+		if btype == "OSMData" {
+			idx.Entries = append(idx.Entries, BlobIndexEntry{
+				Offset: offset, Size: rr.pos - offset, Kind: -1,
+			})
+		}
+	}
+	return idx, nil
+}
+
+// procBlockIDs fills the kind and id range of the entry from the block data.
+func procBlockIDs(data []byte, entry *BlobIndexEntry) error {
+	entry.Kind = -1
+	var first bool
+	add := func(id int64) {
+		if !first || id < entry.MinID {
+			entry.MinID = id
+		}
+		if !first || id > entry.MaxID {
+			entry.MaxID = id
+		}
+		first = true
+	}
+	return pbf.ForEachField(data, func(f pbf.Field) error {
+		if f.Num() != 2 {
+			return nil
+		}
+		return pbf.ForEachField(f.Data(), func(f pbf.Field) error {
+			switch f.Num() {
+			case 1:
+				entry.Kind = DataKindNodes
+				return pbf.ForEachField(f.Data(), func(f pbf.Field) error {
+					if f.Num() == 1 {
+						add(f.Int64())
+					}
+					return nil
+				})
+			case 2:
+				entry.Kind = DataKindNodes
+				return pbf.ForEachField(f.Data(), func(f pbf.Field) error {
+					if f.Num() != 1 {
+						return nil
+					}
+					var id int64
+					return f.ForEachPackedInt64(func(x int64) error {
+						id += x
+						add(id)
+						return nil
+					})
+				})
+			case 3, 4:
+				if f.Num() == 3 {
+					entry.Kind = DataKindWays
+				} else {
+					entry.Kind = DataKindRelations
+				}
+				return pbf.ForEachField(f.Data(), func(f pbf.Field) error {
+					if f.Num() == 1 {
+						add(int64(f.Uint64()))
+					}
+					return nil
+				})
+			}
+			return nil
+		})
+	})
+}
+
+// First returns the first block of the provided kind.
+func (idx *BlobIndex) First(kind DataKind) (entry BlobIndexEntry, ok bool) {
+	for _, entry := range idx.Entries {
+		if entry.Kind == kind {
+			return entry, true
+		}
+	}
+	return BlobIndexEntry{}, false
+}
+
+// Search returns the block that may contain the node, way, or relation with
+// the provided id. The file must be sorted by type then id.
+func (idx *BlobIndex) Search(kind DataKind, id int64) (
+	entry BlobIndexEntry, ok bool,
+) {
+	i := sort.Search(len(idx.Entries), func(i int) bool {
+		e := idx.Entries[i]
+		return e.Kind > kind || (e.Kind == kind && e.MaxID >= id)
+	})
+	if i < len(idx.Entries) {
+		e := idx.Entries[i]
+		if e.Kind == kind && e.MinID <= id {
+			return e, true
+		}
+	}
+	return BlobIndexEntry{}, false
+}
+
+// WriteTo writes the index to w, such as to a sidecar file, so it can later
+// be loaded using ReadBlobIndex.
+func (idx *BlobIndex) WriteTo(w io.Writer) (n int64, err error) {
+	bw := bufio.NewWriter(w)
+	buf := make([]byte, 33)
+	copy(buf, blobIndexMagic)
+	binary.LittleEndian.PutUint64(buf[8:], uint64(len(idx.Entries)))
+	nn, err := bw.Write(buf[:16])
+	n += int64(nn)
+	if err != nil {
+		return n, err
+	}
+	for _, e := range idx.Entries {
+		binary.LittleEndian.PutUint64(buf[0:], uint64(e.Offset))
+		binary.LittleEndian.PutUint64(buf[8:], uint64(e.Size))
+		buf[16] = byte(e.Kind)
+		binary.LittleEndian.PutUint64(buf[17:], uint64(e.MinID))
+		binary.LittleEndian.PutUint64(buf[25:], uint64(e.MaxID))
+		nn, err := bw.Write(buf[:33])
+		n += int64(nn)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, bw.Flush()
+}
+
+// ReadBlobIndex reads an index that was written using BlobIndex.WriteTo.
+func ReadBlobIndex(r io.Reader) (*BlobIndex, error) {
+	br := bufio.NewReader(r)
+	buf := make([]byte, 33)
+	if _, err := io.ReadFull(br, buf[:16]); err != nil {
+		return nil, err
+	}
+	if string(buf[:8]) != blobIndexMagic {
+		return nil, errors.New("invalid blob index")
+	}
+	count := binary.LittleEndian.Uint64(buf[8:])
+	idx := new(BlobIndex)
+	for i := uint64(0); i < count; i++ {
+		if _, err := io.ReadFull(br, buf); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		idx.Entries = append(idx.Entries, BlobIndexEntry{
+			Offset: int64(binary.LittleEndian.Uint64(buf[0:])),
+			Size:   int64(binary.LittleEndian.Uint64(buf[8:])),
+			Kind:   DataKind(int8(buf[16])),
+			MinID:  int64(binary.LittleEndian.Uint64(buf[17:])),
+			MaxID:  int64(binary.LittleEndian.Uint64(buf[25:])),
+		})
+	}
+	return idx, nil
+}
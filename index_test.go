@@ -0,0 +1,84 @@
+// Copyright 2021 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package osmfile
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestBlobIndex(t *testing.T) {
+	data := writeTestFile(t, Header{})
+	idx, err := BuildBlobIndex(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idx.Entries) != 4 {
+		t.Fatalf("expected 4 entries, got %d", len(idx.Entries))
+	}
+	entry, ok := idx.Search(DataKindNodes, 8050)
+	if !ok || entry.MinID != 8001 || entry.MaxID != testNumNodes {
+		t.Fatalf("wrong entry for node 8050: %v", entry)
+	}
+	_, block, err := NewBlockReader(bytes.NewReader(
+		data[entry.Offset : entry.Offset+entry.Size])).ReadBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.NumNodes() != 100 || block.NodeAt(0).ID() != 8001 {
+		t.Fatal("wrong block at the entry offset")
+	}
+	if entry, ok := idx.First(DataKindWays); !ok || entry.MinID != 10001 {
+		t.Fatalf("wrong first way entry: %v", entry)
+	}
+
+	var buf bytes.Buffer
+	if _, err := idx.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	idx2, err := ReadBlobIndex(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idx2.Entries) != len(idx.Entries) {
+		t.Fatal("index changed after reading it back")
+	}
+	for i := range idx.Entries {
+		if idx2.Entries[i] != idx.Entries[i] {
+			t.Fatal("index changed after reading it back")
+		}
+	}
+}
+
+func TestBlobIndexHeaders(t *testing.T) {
+	data := writeTestFile(t, Header{})
+	idx, err := BuildBlobIndex(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a pipe can't seek, so the data is read over instead
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write(data)
+		pw.Close()
+	}()
+	for _, r := range []io.Reader{bytes.NewReader(data), pr} {
+		hidx, err := BuildBlobIndexHeaders(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(hidx.Entries) != len(idx.Entries) {
+			t.Fatalf("expected %d entries, got %d", len(idx.Entries),
+				len(hidx.Entries))
+		}
+		for i, e := range hidx.Entries {
+			if e.Offset != idx.Entries[i].Offset ||
+				e.Size != idx.Entries[i].Size || e.Kind != -1 {
+				t.Fatalf("wrong entry %d: %v", i, e)
+			}
+		}
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2021 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package pbf
+
+import "encoding/binary"
+
+func appendUvarint(dst []byte, x uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], x)
+	return append(dst, buf[:n]...)
+}
+
+func appendVarint(dst []byte, x int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(buf[:], x)
+	return append(dst, buf[:n]...)
+}
+
+func appendKey(dst []byte, num uint64, typ fieldType) []byte {
+	return appendUvarint(dst, num<<3|uint64(typ))
+}
+
+// AppendUint64 appends a varint field. Use for int32, int64, uint32, uint64,
+// bool, and enum fields.
+func AppendUint64(dst []byte, num uint64, x uint64) []byte {
+	dst = appendKey(dst, num, typeVarint)
+	return appendUvarint(dst, x)
+}
+
+// AppendInt64 appends a zigzag encoded varint field. Use for sint32 and
+// sint64 fields.
+func AppendInt64(dst []byte, num uint64, x int64) []byte {
+	dst = appendKey(dst, num, typeVarint)
+	return appendVarint(dst, x)
+}
+
+// AppendBytes appends a length delimited field. Use for bytes, string, and
+// embedded message fields.
+func AppendBytes(dst []byte, num uint64, data []byte) []byte {
+	dst = appendKey(dst, num, typeLength)
+	dst = appendUvarint(dst, uint64(len(data)))
+	return append(dst, data...)
+}
+
+// AppendString appends a string field.
+func AppendString(dst []byte, num uint64, s string) []byte {
+	dst = appendKey(dst, num, typeLength)
+	dst = appendUvarint(dst, uint64(len(s)))
+	return append(dst, s...)
+}
+
+// AppendPackedUint64 appends a packed repeated varint field. Nothing is
+// appended when xs is empty.
+func AppendPackedUint64(dst []byte, num uint64, xs []uint64) []byte {
+	if len(xs) == 0 {
+		return dst
+	}
+	var size int
+	var buf [binary.MaxVarintLen64]byte
+	for _, x := range xs {
+		size += binary.PutUvarint(buf[:], x)
+	}
+	dst = appendKey(dst, num, typeLength)
+	dst = appendUvarint(dst, uint64(size))
+	for _, x := range xs {
+		dst = appendUvarint(dst, x)
+	}
+	return dst
+}
+
+// AppendPackedInt64 appends a packed repeated zigzag encoded varint field.
+// Nothing is appended when xs is empty.
+func AppendPackedInt64(dst []byte, num uint64, xs []int64) []byte {
+	if len(xs) == 0 {
+		return dst
+	}
+	var size int
+	var buf [binary.MaxVarintLen64]byte
+	for _, x := range xs {
+		size += binary.PutVarint(buf[:], x)
+	}
+	dst = appendKey(dst, num, typeLength)
+	dst = appendUvarint(dst, uint64(size))
+	for _, x := range xs {
+		dst = appendVarint(dst, x)
+	}
+	return dst
+}
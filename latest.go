@@ -5,8 +5,12 @@
 package osmfile
 
 import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -38,7 +42,17 @@ var AllMirrors = []string{
 // Latest returns the latest (most recent) planet names on the primary OSM
 // server.
 func Latest() (names []string, err error) {
-	resp, err := http.Get(primary)
+	return LatestWithOptions(nil)
+}
+
+// LatestWithOptions returns the latest (most recent) planet names on the
+// primary OSM server using the provided options.
+func LatestWithOptions(opts *DownloadOptions) (names []string, err error) {
+	req, err := opts.newRequest(opts.context(), "GET", opts.primaryURL())
+	if err != nil {
+		return nil, err
+	}
+	resp, err := opts.client(0).Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -71,14 +85,27 @@ func Latest() (names []string, err error) {
 // Mirrors returns a list of OSM mirror urls that are hosting the planet file
 // for the provide name.
 func Mirrors(name string) (mirrors []string, err error) {
-	client := &http.Client{Timeout: time.Second * 15}
+	return MirrorsWithOptions(name, nil)
+}
+
+// MirrorsWithOptions returns a list of OSM mirror urls that are hosting the
+// planet file for the provide name using the provided options.
+func MirrorsWithOptions(name string, opts *DownloadOptions) (
+	mirrors []string, err error,
+) {
+	client := opts.client(time.Second * 15)
+	ctx := opts.context()
 	var wg sync.WaitGroup
 	var mu sync.Mutex
 	for _, mirror := range AllMirrors {
 		wg.Add(1)
 		go func(url string) {
 			defer wg.Done()
-			resp, err := client.Head(url)
+			req, err := opts.newRequest(ctx, "HEAD", url)
+			if err != nil {
+				return
+			}
+			resp, err := client.Do(req)
 			if err == nil {
 				resp.Body.Close()
 				if resp.StatusCode == 200 {
@@ -105,6 +132,12 @@ type Downloader interface {
 	Stop()
 }
 
+// RateLimiter is implemented by the Downloaders of this package, for changing
+// the rate limit of a download while it's in progress.
+type RateLimiter interface {
+	SetRateLimit(bytesPerSec int64)
+}
+
 type dlfut struct {
 	cond       *sync.Cond
 	done       bool
@@ -112,6 +145,21 @@ type dlfut struct {
 	err        error
 	downloaded int64
 	size       int64
+	avail      int64 // length of the downloaded data at the start of file
+	retries    int
+	restarts   int // times the download started over
+	ctx        context.Context
+	cancel     context.CancelFunc // called by Stop
+	rate       rateState
+}
+
+func newDlfut(ctx context.Context) *dlfut {
+	dl := new(dlfut)
+	dl.cond = sync.NewCond(&sync.Mutex{})
+	dl.ctx, dl.cancel = context.WithCancel(ctx)
+	dl.rate.start = time.Now()
+	dl.rate.window = dl.rate.start
+	return dl
 }
 
 // DownloadStatus ...
@@ -120,6 +168,12 @@ type DownloadStatus struct {
 	Path       string
 	Downloaded int64
 	Size       int64
+	Retries    int // number of times that the download was retried
+
+	StartTime    time.Time
+	Speed        float64       // current speed in bytes per second
+	AverageSpeed float64       // average speed in bytes per second
+	ETA          time.Duration // estimated time remaining
 }
 
 func (dl *dlfut) Stop() {
@@ -130,6 +184,7 @@ func (dl *dlfut) Stop() {
 		return
 	}
 	dl.err = errors.New("stopped")
+	dl.cancel()
 }
 
 func (dl *dlfut) Error() error {
@@ -144,12 +199,15 @@ func (dl *dlfut) Error() error {
 func (dl *dlfut) Status() DownloadStatus {
 	dl.cond.L.Lock()
 	defer dl.cond.L.Unlock()
-	return DownloadStatus{
+	status := DownloadStatus{
 		Done:       dl.done,
 		Path:       dl.path,
 		Downloaded: dl.downloaded,
 		Size:       dl.size,
+		Retries:    dl.retries,
 	}
+	dl.rateStatus(&status)
+	return status
 }
 
 type dlErrReader struct {
@@ -164,51 +222,65 @@ func (rd *dlErrReader) Close() error {
 }
 
 type dlReader struct {
-	cerr error // error at creation
-	dl   *dlfut
-	f    *os.File
-	read int64
+	cerr     error // error at creation
+	dl       *dlfut
+	f        *os.File
+	read     int64
+	restarts int
 }
 
+var errRestarted = errors.New("download restarted after a checksum mismatch")
+
 func (rd *dlReader) File() *os.File {
 	return rd.f
 }
 
 func (rd *dlReader) Read(p []byte) (int, error) {
+	// Only read the data that is available. The rest of the file may not
+	// be downloaded yet, or may be sparse when downloading in segments.
+	rd.dl.cond.L.Lock()
 	for {
-		n, err := rd.f.Read(p)
-		if n > 0 {
-			rd.read += int64(n)
-		}
-		rd.dl.cond.L.Lock()
 		if rd.dl.err != nil {
 			rd.dl.cond.L.Unlock()
-			return n, rd.dl.err
+			return 0, rd.dl.err
 		}
-		if err == io.EOF {
-			if n > 0 {
-				if rd.read < rd.dl.size {
-					err = nil
-				}
-				rd.dl.cond.L.Unlock()
-				return n, err
-			}
-			if rd.read > rd.dl.size {
-				rd.dl.cond.L.Unlock()
-				return n, errors.New("corrupt: too much data written")
-			}
-			if rd.read == rd.dl.size {
+		if rd.restarts != rd.dl.restarts {
+			if rd.read > 0 {
+				// the data that was already read is bad
 				rd.dl.cond.L.Unlock()
-				return n, io.EOF
+				return 0, errRestarted
 			}
-			rd.dl.cond.Wait()
+			rd.restarts = rd.dl.restarts
+		}
+		if rd.read < rd.dl.avail {
+			break
+		}
+		if rd.read >= rd.dl.size {
 			rd.dl.cond.L.Unlock()
-			continue
+			return 0, io.EOF
 		}
-		rd.dl.cond.L.Unlock()
-		return n, err
+		rd.dl.cond.Wait()
 	}
-
+	avail := rd.dl.avail
+	rd.dl.cond.L.Unlock()
+	if int64(len(p)) > avail-rd.read {
+		p = p[:avail-rd.read]
+	}
+	n, err := rd.f.Read(p)
+	rd.read += int64(n)
+	if err == io.EOF {
+		if n == 0 {
+			rd.dl.cond.L.Lock()
+			restarted := rd.restarts != rd.dl.restarts
+			rd.dl.cond.L.Unlock()
+			if restarted {
+				return 0, errRestarted
+			}
+			return 0, io.ErrUnexpectedEOF
+		}
+		err = nil
+	}
+	return n, err
 }
 func (rd *dlReader) Close() error {
 	return rd.f.Close()
@@ -232,22 +304,67 @@ func (dl *dlfut) Reader() io.ReadCloser {
 		if dl.done {
 			return f
 		}
-		return &dlReader{dl: dl, f: f}
+		return &dlReader{dl: dl, f: f, restarts: dl.restarts}
 	}
 }
 
+// restart truncates the file of a resumed download that did not match its
+// checksum, so that it's downloaded again from the start. Readers that already
+// read some of the data fail.
+func (dl *dlfut) restart(path string) error {
+	dl.cond.L.Lock()
+	dl.restarts++
+	dl.downloaded = 0
+	dl.avail = 0
+	dl.cond.Broadcast()
+	dl.cond.L.Unlock()
+	return os.Truncate(path, 0)
+}
+
+// ChecksumError is returned when a downloaded file does not match its
+// published checksum.
+type ChecksumError struct {
+	Path     string
+	Expected string
+	Actual   string
+}
+
+func (err *ChecksumError) Error() string {
+	return fmt.Sprintf("checksum mismatch: %s: expected md5 %s, got %s",
+		err.Path, err.Expected, err.Actual)
+}
+
 // Download the OSM planet file into the provide file path.
 func Download(planetURL string, path string) Downloader {
-	dl := new(dlfut)
-	dl.cond = sync.NewCond(&sync.Mutex{})
+	return DownloadWithOptions(planetURL, path, nil)
+}
+
+// DownloadWithOptions downloads the OSM planet file into the provide file
+// path using the provided options.
+func DownloadWithOptions(planetURL string, path string,
+	opts *DownloadOptions,
+) Downloader {
+	if opts == nil {
+		opts = &DownloadOptions{}
+	}
+	dl := newDlfut(opts.context())
+	dl.SetRateLimit(opts.RateLimit)
 	go func() {
 		defer func() {
+			dl.cancel()
 			dl.cond.L.Lock()
 			dl.done = true
 			dl.cond.Broadcast()
 			dl.cond.L.Unlock()
 		}()
-		if err := download(planetURL, path, dl); err != nil {
+		var err error
+		for attempts := 1; ; attempts++ {
+			err = download(planetURL, path, dl, opts)
+			if err == nil || !dl.retry(opts.Retry, attempts, err) {
+				break
+			}
+		}
+		if err != nil {
 			dl.cond.L.Lock()
 			if dl.err == nil {
 				dl.err = err
@@ -259,22 +376,66 @@ func Download(planetURL string, path string) Downloader {
 	return dl
 }
 
-func download(url string, path string, dl *dlfut) error {
-	client := &http.Client{}
+// fetchChecksum returns the md5 checksum from the .md5 file for the url.
+func fetchChecksum(ctx context.Context, client *http.Client, url string,
+	opts *DownloadOptions,
+) (string, error) {
+	req, err := opts.newRequest(ctx, "GET", url+".md5")
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("checksum: %s", resp.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return "", err
+	}
+	// The file is formatted like the output of md5sum, "<md5>  <name>"
+	fields := strings.Fields(string(body))
+	if len(fields) == 0 || len(fields[0]) != 32 {
+		return "", errors.New("checksum: invalid md5 file")
+	}
+	return strings.ToLower(fields[0]), nil
+}
+
+// verifyChecksum compares the expected checksum to the sum of the hash.
+func verifyChecksum(path string, expected string, h hash.Hash) error {
+	sum := hex.EncodeToString(h.Sum(nil))
+	if sum != expected {
+		return &ChecksumError{Path: path, Expected: expected, Actual: sum}
+	}
+	return nil
+}
+
+func download(url string, path string, dl *dlfut, opts *DownloadOptions,
+) error {
+	client := opts.client(0)
+	a := newAttempt(dl.ctx, opts.Retry)
+	defer a.done()
 
 	var primaryURL string
 	if strings.HasPrefix(filepath.Base(url), "planet-") {
-		primaryURL = primary + filepath.Base(url)
+		primaryURL = opts.primaryURL() + filepath.Base(url)
 	} else {
 		primaryURL = url
 	}
-	res, err := client.Head(primaryURL)
+	req, err := opts.newRequest(a.ctx, "HEAD", primaryURL)
 	if err != nil {
 		return err
 	}
+	res, err := client.Do(req)
+	if err != nil {
+		return a.err(err)
+	}
 	res.Body.Close()
 	if res.StatusCode != 200 {
-		return errors.New(res.Status)
+		return newHTTPError(res)
 	}
 	if res.Header.Get("Content-Type") != "application/octet-stream" {
 		if res.Header.Get("Content-Type") == "application/x-bittorrent" {
@@ -285,6 +446,22 @@ func download(url string, path string, dl *dlfut) error {
 	if err != nil {
 		return err
 	}
+	var checksum string
+	if opts.Checksum {
+		checksum, err = fetchChecksum(a.ctx, client, primaryURL, opts)
+		if err != nil {
+			return err
+		}
+	}
+	// The file of an unfinished segmented download is sparse, so it can
+	// only be resumed in segments.
+	a.done()
+	_, err = os.Stat(segmentsPath(path))
+	if opts.Segments > 1 || err == nil {
+		urls := append([]string{url}, opts.Mirrors...)
+		return downloadSegments(client, urls, path, size, checksum, dl,
+			opts)
+	}
 	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0666)
 	if err != nil {
 		return err
@@ -295,40 +472,71 @@ func download(url string, path string, dl *dlfut) error {
 		return err
 	}
 	if start > size {
-		return errors.New("corrupt: too much data written")
+		return errCorrupt
+	}
+	var md5h hash.Hash
+	if opts.Checksum {
+		// hash the data from a previous download
+		md5h = md5.New()
+		_, err := io.Copy(md5h, io.NewSectionReader(f, 0, start))
+		if err != nil {
+			return err
+		}
+	}
+	verify := func() error {
+		if md5h == nil {
+			return nil
+		}
+		err := verifyChecksum(path, checksum, md5h)
+		var cerr *ChecksumError
+		if errors.As(err, &cerr) && start > 0 {
+			// The data from a previous download may be bad, so start over,
+			// otherwise every later download would fail too.
+			if err := dl.restart(path); err != nil {
+				return err
+			}
+			return download(url, path, dl, opts)
+		}
+		return err
 	}
 
 	dl.cond.L.Lock()
 	dl.path = path
 	dl.size = size
 	dl.downloaded = start
+	dl.avail = start
 	dl.cond.Broadcast()
 	dl.cond.L.Unlock()
 	if start == size {
 		// already downloaded
-		return nil
+		return verify()
 	}
-	req, err := http.NewRequest("GET", url, nil)
+	// The timers of the attempt only cover the network requests, and not
+	// the hashing of a previous download, which may take a while.
+	a = newAttempt(dl.ctx, opts.Retry)
+	defer a.done()
+	req, err = opts.newRequest(a.ctx, "GET", url)
 	if err != nil {
 		return err
 	}
 	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, size-1))
-	if res.StatusCode != 206 && res.StatusCode != 200 {
-		return errors.New(res.Status)
-	}
 	res, err = client.Do(req)
 	if err != nil {
-		return err
+		return a.err(err)
 	}
 	defer res.Body.Close()
-	packet := make([]byte, 4096)
+	if res.StatusCode != 206 && (res.StatusCode != 200 || start > 0) {
+		return newHTTPError(res)
+	}
+	packet := make([]byte, opts.bufferSize(4096))
 	written := start
 	for {
 		n, err := res.Body.Read(packet)
 		if n > 0 {
+			a.pause()
 			written += int64(n)
 			if written > size {
-				return errors.New("corrupt: too much data written")
+				return errCorrupt
 			}
 			dl.cond.L.Lock()
 			if dl.err != nil {
@@ -340,9 +548,18 @@ func download(url string, path string, dl *dlfut) error {
 				dl.cond.L.Unlock()
 				return err
 			}
+			if md5h != nil {
+				md5h.Write(packet[:n])
+			}
 			dl.downloaded = written
+			dl.avail = written
+			dl.received(n)
 			dl.cond.Broadcast()
 			dl.cond.L.Unlock()
+			if err := dl.throttle(n); err != nil {
+				return err
+			}
+			a.progress()
 		}
 		if err != nil {
 			if err == io.EOF {
@@ -351,7 +568,7 @@ func download(url string, path string, dl *dlfut) error {
 				}
 				break
 			}
-			return err
+			return a.err(err)
 		}
 	}
 	if err := f.Sync(); err != nil {
@@ -360,7 +577,7 @@ func download(url string, path string, dl *dlfut) error {
 	if err := f.Close(); err != nil {
 		return err
 	}
-	return nil
+	return verify()
 }
 
 func validBaseName(name string) bool {
@@ -0,0 +1,268 @@
+// Copyright 2021 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package osmfile
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+)
+
+// ErrNodeNotFound is returned when a node location is not in a store.
+var ErrNodeNotFound = errors.New("node not found")
+
+// ErrUnsupported is returned by NewDenseFileLocationStore on platforms that
+// do not support memory mapped files.
+var ErrUnsupported = errors.New("not supported on this platform")
+
+// Point is a location in degrees.
+type Point struct {
+	Lat float64
+	Lon float64
+}
+
+// NodeLocationStore stores the locations of nodes, so that the geometries of
+// ways can be resolved. Locations are stored with the same precision as
+// the PBF format, which is 1e-7 degrees.
+type NodeLocationStore interface {
+	// Set stores the location of a node.
+	Set(id int64, lat, lon float64) error
+	// Get returns the location of a node.
+	Get(id int64) (lat, lon float64, ok bool)
+}
+
+// encodeLocation packs a location into a non-zero uint64.
+func encodeLocation(lat, lon float64) uint64 {
+	ilat := uint32(int64(math.Round(lat*1e7)) + 900000001)
+	ilon := uint32(int64(math.Round(lon*1e7)) + 1800000001)
+	return uint64(ilat)<<32 | uint64(ilon)
+}
+
+func decodeLocation(x uint64) (lat, lon float64) {
+	lat = float64(int64(x>>32)-900000001) / 1e7
+	lon = float64(int64(x&0xFFFFFFFF)-1800000001) / 1e7
+	return lat, lon
+}
+
+// StoreNodeLocations stores the locations of all nodes in the block.
+func StoreNodeLocations(store NodeLocationStore, block Block) error {
+	for i := 0; i < block.NumNodes(); i++ {
+		n := block.NodeAt(i)
+		if err := store.Set(n.ID(), n.Lat(), n.Lon()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Coordinates returns the locations of all nodes in the way. The locations
+// carried by the way are used when available, otherwise they're retrieved
+// from the store, which may be nil if the way has locations.
+// Returns an error wrapping ErrNodeNotFound if a node is missing.
+func (w Way) Coordinates(store NodeLocationStore) ([]Point, error) {
+	points := make([]Point, w.NumRefs())
+	if w.HasLocations() {
+		for i := range points {
+			points[i] = Point{Lat: w.LatAt(i), Lon: w.LonAt(i)}
+		}
+		return points, nil
+	}
+	if store == nil {
+		return nil, errors.New("no location store")
+	}
+	for i := range points {
+		ref := w.RefAt(i)
+		lat, lon, ok := store.Get(ref)
+		if !ok {
+			return nil, fmt.Errorf("node %d: %w", ref, ErrNodeNotFound)
+		}
+		points[i] = Point{Lat: lat, Lon: lon}
+	}
+	return points, nil
+}
+
+// SparseLocationStore is an in-memory NodeLocationStore backed by a hashmap.
+// It's best suited for small extracts.
+// It's not safe for concurrent use.
+type SparseLocationStore struct {
+	m map[int64]uint64
+}
+
+// NewSparseLocationStore returns a new in-memory sparse location store.
+func NewSparseLocationStore() *SparseLocationStore {
+	return &SparseLocationStore{m: make(map[int64]uint64)}
+}
+
+// Set stores the location of a node.
+func (s *SparseLocationStore) Set(id int64, lat, lon float64) error {
+	s.m[id] = encodeLocation(lat, lon)
+	return nil
+}
+
+// Get returns the location of a node.
+func (s *SparseLocationStore) Get(id int64) (lat, lon float64, ok bool) {
+	x, ok := s.m[id]
+	if !ok {
+		return 0, 0, false
+	}
+	lat, lon = decodeLocation(x)
+	return lat, lon, true
+}
+
+// Len returns the number of stored locations.
+func (s *SparseLocationStore) Len() int {
+	return len(s.m)
+}
+
+// SortedLocationStore is an in-memory NodeLocationStore backed by a sorted
+// array. It uses half the memory of a SparseLocationStore, but nodes must be
+// stored in ascending id order, which is the order of a PBF file sorted by
+// type then id.
+// It's not safe for concurrent use.
+type SortedLocationStore struct {
+	ids  []int64
+	locs []uint64
+}
+
+// NewSortedLocationStore returns a new in-memory sorted location store.
+func NewSortedLocationStore() *SortedLocationStore {
+	return &SortedLocationStore{}
+}
+
+// Set stores the location of a node. The id must be larger than the id of
+// the previously stored node.
+func (s *SortedLocationStore) Set(id int64, lat, lon float64) error {
+	if len(s.ids) > 0 && id <= s.ids[len(s.ids)-1] {
+		return errors.New("ids not in ascending order")
+	}
+	s.ids = append(s.ids, id)
+	s.locs = append(s.locs, encodeLocation(lat, lon))
+	return nil
+}
+
+// Get returns the location of a node.
+func (s *SortedLocationStore) Get(id int64) (lat, lon float64, ok bool) {
+	i := sort.Search(len(s.ids), func(i int) bool { return s.ids[i] >= id })
+	if i == len(s.ids) || s.ids[i] != id {
+		return 0, 0, false
+	}
+	lat, lon = decodeLocation(s.locs[i])
+	return lat, lon, true
+}
+
+// Len returns the number of stored locations.
+func (s *SortedLocationStore) Len() int {
+	return len(s.ids)
+}
+
+// denseGrowSize is the size that dense files grow by.
+const denseGrowSize = 1 << 28
+
+// DenseFileLocationStore is a NodeLocationStore backed by a memory mapped
+// file, using eight bytes per node id, up to the largest stored id. The file
+// is sparse on most filesystems, and it can hold the locations of the entire
+// planet without consuming much memory. It's only available on platforms
+// that support memory mapped files, such as Linux and macOS.
+// It's not safe for concurrent use.
+type DenseFileLocationStore struct {
+	f    *os.File
+	data []byte
+}
+
+// NewDenseFileLocationStore opens or creates a dense location store file at
+// the provided path. The store must be closed when no longer needed.
+// Returns ErrUnsupported when memory mapping is not available.
+func NewDenseFileLocationStore(path string) (*DenseFileLocationStore, error) {
+	if !mmapSupported {
+		return nil, ErrUnsupported
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0666)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	s := &DenseFileLocationStore{f: f}
+	if size := fi.Size() &^ 7; size > 0 {
+		s.data, err = mapFile(f, int(size))
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *DenseFileLocationStore) grow(size int) error {
+	size = (size + denseGrowSize - 1) / denseGrowSize * denseGrowSize
+	if s.data != nil {
+		if err := unmapFile(s.f, s.data); err != nil {
+			return err
+		}
+		s.data = nil
+	}
+	if err := s.f.Truncate(int64(size)); err != nil {
+		return err
+	}
+	data, err := mapFile(s.f, size)
+	if err != nil {
+		return err
+	}
+	s.data = data
+	return nil
+}
+
+// Set stores the location of a node.
+func (s *DenseFileLocationStore) Set(id int64, lat, lon float64) error {
+	if s.f == nil {
+		return os.ErrClosed
+	}
+	if id < 0 {
+		return errors.New("negative id")
+	}
+	if id*8+8 > int64(len(s.data)) {
+		if err := s.grow(int(id*8 + 8)); err != nil {
+			return err
+		}
+	}
+	binary.LittleEndian.PutUint64(s.data[id*8:], encodeLocation(lat, lon))
+	return nil
+}
+
+// Get returns the location of a node.
+func (s *DenseFileLocationStore) Get(id int64) (lat, lon float64, ok bool) {
+	if id < 0 || id*8+8 > int64(len(s.data)) {
+		return 0, 0, false
+	}
+	x := binary.LittleEndian.Uint64(s.data[id*8:])
+	if x == 0 {
+		return 0, 0, false
+	}
+	lat, lon = decodeLocation(x)
+	return lat, lon, true
+}
+
+// Close flushes and closes the store file.
+func (s *DenseFileLocationStore) Close() error {
+	if s.f == nil {
+		return os.ErrClosed
+	}
+	var err error
+	if s.data != nil {
+		err = unmapFile(s.f, s.data)
+		s.data = nil
+	}
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	s.f = nil
+	return err
+}
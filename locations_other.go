@@ -0,0 +1,24 @@
+// Copyright 2021 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package osmfile
+
+import (
+	"os"
+)
+
+// Memory mapping is not available on this platform. Reading the file into
+// memory instead would need gigabytes for the planet, so it's not supported.
+const mmapSupported = false
+
+func mapFile(f *os.File, size int) ([]byte, error) {
+	return nil, ErrUnsupported
+}
+
+func unmapFile(f *os.File, data []byte) error {
+	return nil
+}
@@ -0,0 +1,142 @@
+// Copyright 2021 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package osmfile
+
+import "time"
+
+// blockInfo is the metadata of a node, way, or relation. It's only available
+// when the block was read using the Metadata option.
+type blockInfo struct {
+	version   int32
+	uid       int32
+	userSid   uint32
+	visible   bool
+	timestamp int64 // in units of the block date granularity
+	changeset int64
+}
+
+func (b Block) info(infos []blockInfo, index int) blockInfo {
+	if index < len(infos) {
+		return infos[index]
+	}
+	return blockInfo{visible: true}
+}
+
+func (b Block) infoTimestamp(info blockInfo) time.Time {
+	if info.timestamp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, info.timestamp*b.dateGranularity*1e6).UTC()
+}
+
+func (b Block) infoUser(info blockInfo) string {
+	if int(info.userSid) >= len(b.strings) {
+		return ""
+	}
+	return b.strings[info.userSid]
+}
+
+// HasMetadata returns true if the block was read using the Metadata option.
+func (b Block) HasMetadata() bool {
+	return len(b.nodeInfos) > 0 || len(b.wayInfos) > 0 ||
+		len(b.relationInfos) > 0
+}
+
+// Version returns the version of the node, or zero if unknown.
+func (n Node) Version() int {
+	return int(n.block.info(n.block.nodeInfos, n.index).version)
+}
+
+// Timestamp returns the time the node was last modified, or the zero time
+// if unknown.
+func (n Node) Timestamp() time.Time {
+	return n.block.infoTimestamp(n.block.info(n.block.nodeInfos, n.index))
+}
+
+// Changeset returns the changeset that last modified the node.
+func (n Node) Changeset() int64 {
+	return n.block.info(n.block.nodeInfos, n.index).changeset
+}
+
+// UID returns the id of the user that last modified the node.
+func (n Node) UID() int {
+	return int(n.block.info(n.block.nodeInfos, n.index).uid)
+}
+
+// User returns the name of the user that last modified the node.
+func (n Node) User() string {
+	return n.block.infoUser(n.block.info(n.block.nodeInfos, n.index))
+}
+
+// Visible returns false if the node was deleted. Only history files contain
+// deleted nodes.
+func (n Node) Visible() bool {
+	return n.block.info(n.block.nodeInfos, n.index).visible
+}
+
+// Version returns the version of the way, or zero if unknown.
+func (w Way) Version() int {
+	return int(w.block.info(w.block.wayInfos, w.index).version)
+}
+
+// Timestamp returns the time the way was last modified, or the zero time
+// if unknown.
+func (w Way) Timestamp() time.Time {
+	return w.block.infoTimestamp(w.block.info(w.block.wayInfos, w.index))
+}
+
+// Changeset returns the changeset that last modified the way.
+func (w Way) Changeset() int64 {
+	return w.block.info(w.block.wayInfos, w.index).changeset
+}
+
+// UID returns the id of the user that last modified the way.
+func (w Way) UID() int {
+	return int(w.block.info(w.block.wayInfos, w.index).uid)
+}
+
+// User returns the name of the user that last modified the way.
+func (w Way) User() string {
+	return w.block.infoUser(w.block.info(w.block.wayInfos, w.index))
+}
+
+// Visible returns false if the way was deleted. Only history files contain
+// deleted ways.
+func (w Way) Visible() bool {
+	return w.block.info(w.block.wayInfos, w.index).visible
+}
+
+// Version returns the version of the relation, or zero if unknown.
+func (r Relation) Version() int {
+	return int(r.block.info(r.block.relationInfos, r.index).version)
+}
+
+// Timestamp returns the time the relation was last modified, or the zero
+// time if unknown.
+func (r Relation) Timestamp() time.Time {
+	return r.block.infoTimestamp(
+		r.block.info(r.block.relationInfos, r.index))
+}
+
+// Changeset returns the changeset that last modified the relation.
+func (r Relation) Changeset() int64 {
+	return r.block.info(r.block.relationInfos, r.index).changeset
+}
+
+// UID returns the id of the user that last modified the relation.
+func (r Relation) UID() int {
+	return int(r.block.info(r.block.relationInfos, r.index).uid)
+}
+
+// User returns the name of the user that last modified the relation.
+func (r Relation) User() string {
+	return r.block.infoUser(r.block.info(r.block.relationInfos, r.index))
+}
+
+// Visible returns false if the relation was deleted. Only history files
+// contain deleted relations.
+func (r Relation) Visible() bool {
+	return r.block.info(r.block.relationInfos, r.index).visible
+}
@@ -0,0 +1,285 @@
+// Copyright 2021 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package osmfile
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Polygon is an outer ring and zero or more inner rings (holes). The first
+// and last points of each ring are the same. Outer rings are
+// counter-clockwise and inner rings are clockwise.
+type Polygon struct {
+	Outer  []Point
+	Inners [][]Point
+}
+
+// AssemblyError is returned when a multipolygon relation cannot be assembled.
+type AssemblyError struct {
+	RelationID int64
+	Reason     string
+}
+
+func (err *AssemblyError) Error() string {
+	return fmt.Sprintf("relation %d: %s", err.RelationID, err.Reason)
+}
+
+// IsMultipolygon returns true if the relation is a type=multipolygon or
+// type=boundary relation.
+func IsMultipolygon(r Relation) bool {
+	typ, _ := r.Tag("type")
+	return typ == "multipolygon" || typ == "boundary"
+}
+
+// multipolygonWay returns true if the member is a way that is part of the
+// area of a multipolygon.
+func multipolygonWay(typ byte, role string) bool {
+	return typ == 1 && (role == "outer" || role == "inner" || role == "")
+}
+
+// Assembler collects the member ways of multipolygon relations and assembles
+// them into polygons. Relations follow ways in an OSM file, so the file is
+// usually read twice. First calling AddRelation for each relation, and then
+// AddWay for each way, after which Assemble may be called for the relations.
+type Assembler struct {
+	want map[int64]bool
+	ways map[int64][]Point
+}
+
+// NewAssembler returns a new multipolygon assembler.
+func NewAssembler() *Assembler {
+	return &Assembler{
+		want: make(map[int64]bool),
+		ways: make(map[int64][]Point),
+	}
+}
+
+// AddRelation registers the member ways of a multipolygon relation, so that
+// they'll be collected by AddWay. Returns false if the relation is not a
+// multipolygon.
+func (a *Assembler) AddRelation(r Relation) bool {
+	if !IsMultipolygon(r) {
+		return false
+	}
+	for i := 0; i < r.NumMembers(); i++ {
+		typ, ref, role := r.MemberAt(i)
+		if multipolygonWay(typ, role) {
+			a.want[ref] = true
+		}
+	}
+	return true
+}
+
+// AddWay collects the way if it's a member of a registered relation. The node
+// locations are resolved using the store.
+func (a *Assembler) AddWay(w Way, store NodeLocationStore) error {
+	if !a.want[w.ID()] {
+		return nil
+	}
+	points, err := w.Coordinates(store)
+	if err != nil {
+		return err
+	}
+	a.ways[w.ID()] = points
+	return nil
+}
+
+// Way returns the collected geometry of a way.
+func (a *Assembler) Way(id int64) ([]Point, bool) {
+	points, ok := a.ways[id]
+	return points, ok
+}
+
+// Assemble assembles the multipolygon relation using the collected ways.
+func (a *Assembler) Assemble(r Relation) ([]Polygon, error) {
+	return AssembleMultipolygon(r, a.Way)
+}
+
+// AssembleMultipolygon assembles a type=multipolygon or type=boundary
+// relation into polygons. The member ways are stitched into rings, which are
+// oriented and then nested by containment. The lookup function returns the
+// geometry of a member way. Returns an *AssemblyError with the reason when
+// the relation is broken.
+func AssembleMultipolygon(r Relation,
+	lookup func(id int64) ([]Point, bool),
+) ([]Polygon, error) {
+	fail := func(format string, args ...interface{}) ([]Polygon, error) {
+		return nil, &AssemblyError{
+			RelationID: r.ID(),
+			Reason:     fmt.Sprintf(format, args...),
+		}
+	}
+	if !IsMultipolygon(r) {
+		return fail("not a multipolygon")
+	}
+	var segs [][]Point
+	for i := 0; i < r.NumMembers(); i++ {
+		typ, ref, role := r.MemberAt(i)
+		if !multipolygonWay(typ, role) {
+			continue
+		}
+		points, ok := lookup(ref)
+		if !ok {
+			return fail("missing way %d", ref)
+		}
+		if len(points) < 2 {
+			return fail("way %d has less than two nodes", ref)
+		}
+		segs = append(segs, points)
+	}
+	if len(segs) == 0 {
+		return fail("no member ways")
+	}
+	rings, reason := stitchRings(segs)
+	if reason != "" {
+		return fail("%s", reason)
+	}
+	return nestRings(rings), nil
+}
+
+// stitchRings joins segments at their endpoints until they form closed
+// rings.
+func stitchRings(segs [][]Point) (rings [][]Point, reason string) {
+	var open [][]Point
+	for _, seg := range segs {
+		if len(seg) > 2 && seg[0] == seg[len(seg)-1] {
+			rings = append(rings, append([]Point(nil), seg...))
+		} else {
+			open = append(open, seg)
+		}
+	}
+	for len(open) > 0 {
+		ring := append([]Point(nil), open[0]...)
+		open = open[1:]
+		for ring[0] != ring[len(ring)-1] {
+			end := ring[len(ring)-1]
+			found := -1
+			for i, seg := range open {
+				if seg[0] == end {
+					ring = append(ring, seg[1:]...)
+					found = i
+					break
+				}
+				if seg[len(seg)-1] == end {
+					for j := len(seg) - 2; j >= 0; j-- {
+						ring = append(ring, seg[j])
+					}
+					found = i
+					break
+				}
+			}
+			if found == -1 {
+				return nil, fmt.Sprintf("unclosed ring at %v,%v",
+					end.Lat, end.Lon)
+			}
+			open = append(open[:found], open[found+1:]...)
+		}
+		rings = append(rings, ring)
+	}
+	for _, ring := range rings {
+		if len(ring) < 4 || ringArea(ring) == 0 {
+			return nil, fmt.Sprintf("degenerate ring at %v,%v",
+				ring[0].Lat, ring[0].Lon)
+		}
+	}
+	return rings, ""
+}
+
+// ringArea returns the signed area of the ring, which is positive for
+// counter-clockwise rings.
+func ringArea(ring []Point) float64 {
+	var area float64
+	for i := 0; i < len(ring)-1; i++ {
+		area += ring[i].Lon*ring[i+1].Lat - ring[i+1].Lon*ring[i].Lat
+	}
+	return area / 2
+}
+
+func reverseRing(ring []Point) {
+	for i, j := 0, len(ring)-1; i < j; i, j = i+1, j-1 {
+		ring[i], ring[j] = ring[j], ring[i]
+	}
+}
+
+// pointInRing returns true if the point is inside of the ring.
+func pointInRing(p Point, ring []Point) bool {
+	var in bool
+	for i, j := 0, len(ring)-2; i < len(ring)-1; j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a.Lat > p.Lat) != (b.Lat > p.Lat) &&
+			p.Lon < (b.Lon-a.Lon)*(p.Lat-a.Lat)/(b.Lat-a.Lat)+a.Lon {
+			in = !in
+		}
+	}
+	return in
+}
+
+// ringInRing returns true if the inner ring is inside of the outer ring. The
+// rings may share vertices, but not cross.
+func ringInRing(inner, outer []Point) bool {
+	shared := make(map[Point]bool, len(outer))
+	for _, p := range outer {
+		shared[p] = true
+	}
+	for _, p := range inner {
+		if !shared[p] {
+			return pointInRing(p, outer)
+		}
+	}
+	mid := Point{
+		Lat: (inner[0].Lat + inner[1].Lat) / 2,
+		Lon: (inner[0].Lon + inner[1].Lon) / 2,
+	}
+	return pointInRing(mid, outer)
+}
+
+// nestRings orients the rings and places the inner rings in their outer
+// rings. A ring that is inside of an odd number of rings is an inner ring.
+func nestRings(rings [][]Point) []Polygon {
+	areas := make([]float64, len(rings))
+	for i, ring := range rings {
+		areas[i] = math.Abs(ringArea(ring))
+	}
+	order := make([]int, len(rings))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return areas[order[i]] > areas[order[j]]
+	})
+	depth := make([]int, len(rings))
+	polyIdx := make([]int, len(rings))
+	var polys []Polygon
+	for i, ri := range order {
+		// the parent is the smallest larger ring that contains this one
+		parent := -1
+		for j := i - 1; j >= 0; j-- {
+			if ringInRing(rings[ri], rings[order[j]]) {
+				parent = order[j]
+				break
+			}
+		}
+		ring := rings[ri]
+		if parent != -1 {
+			depth[ri] = depth[parent] + 1
+		}
+		if depth[ri]%2 == 0 {
+			if ringArea(ring) < 0 {
+				reverseRing(ring)
+			}
+			polyIdx[ri] = len(polys)
+			polys = append(polys, Polygon{Outer: ring})
+		} else {
+			if ringArea(ring) > 0 {
+				reverseRing(ring)
+			}
+			p := &polys[polyIdx[parent]]
+			p.Inners = append(p.Inners, ring)
+		}
+	}
+	return polys
+}
@@ -0,0 +1,96 @@
+// Copyright 2021 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package osmfile
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DownloadOptions are options for Download, Latest, Mirrors, and the
+// replication functions.
+type DownloadOptions struct {
+	// Checksum verifies the file using the md5 checksum that is published
+	// next to it, such as "planet-210329.osm.pbf.md5". A *ChecksumError is
+	// returned when the file does not match. When the file was resumed from
+	// a previous download, it's downloaded again from the start instead.
+	Checksum bool
+	// Segments is the number of segments that are downloaded concurrently.
+	// The file is split into ranges, which are fetched from the planet url
+	// and the Mirrors. The progress is kept in a sidecar file, path plus
+	// ".segments", so that the download can be resumed. The default is one,
+	// which downloads the file from start to end on a single connection.
+	Segments int
+	// Mirrors are the urls of the same file on other servers, such as the
+	// ones returned by the Mirrors function. Only used with Segments.
+	Mirrors []string
+	// Retry is the policy for retrying a download that failed, such as from
+	// a network error. The default is to not retry.
+	Retry *RetryPolicy
+	// HTTPClient is the client used for all requests, such as one with a
+	// proxy or custom TLS config. The default is a new http.Client, with a
+	// 15 second timeout for Mirrors.
+	HTTPClient *http.Client
+	// Context cancels the requests and downloads when done. Stopping a
+	// download cancels its context too.
+	Context context.Context
+	// PrimaryURL is the url of the planet directory on the primary OSM
+	// server, which is used by Latest and for the size and checksum of
+	// planet files. The default is "https://planet.openstreetmap.org/pbf/".
+	PrimaryURL string
+	// UserAgent is the User-Agent header of all requests.
+	UserAgent string
+	// BufferSize is the size of the buffer used for reading the response of
+	// a download. The default is 4KB, or 64KB for segments.
+	BufferSize int
+	// RateLimit is the maximum transfer rate of a download in bytes per
+	// second, which may be changed using the RateLimiter interface of the
+	// Downloader. The default is no limit.
+	RateLimit int64
+}
+
+func (opts *DownloadOptions) client(timeout time.Duration) *http.Client {
+	if opts != nil && opts.HTTPClient != nil {
+		return opts.HTTPClient
+	}
+	return &http.Client{Timeout: timeout}
+}
+
+func (opts *DownloadOptions) context() context.Context {
+	if opts != nil && opts.Context != nil {
+		return opts.Context
+	}
+	return context.Background()
+}
+
+func (opts *DownloadOptions) primaryURL() string {
+	if opts != nil && opts.PrimaryURL != "" {
+		return strings.TrimSuffix(opts.PrimaryURL, "/") + "/"
+	}
+	return primary
+}
+
+func (opts *DownloadOptions) bufferSize(def int) int {
+	if opts != nil && opts.BufferSize > 0 {
+		return opts.BufferSize
+	}
+	return def
+}
+
+// newRequest returns a new request with the user agent.
+func (opts *DownloadOptions) newRequest(ctx context.Context, method,
+	url string,
+) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if opts != nil && opts.UserAgent != "" {
+		req.Header.Set("User-Agent", opts.UserAgent)
+	}
+	return req, nil
+}
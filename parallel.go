@@ -0,0 +1,155 @@
+// Copyright 2021 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package osmfile
+
+import (
+	"errors"
+	"io"
+	"runtime"
+	"sync"
+)
+
+var errReaderClosed = errors.New("reader closed")
+
+type parallelJob struct {
+	n     int
+	data  []byte
+	block Block
+	err   error
+	done  chan struct{}
+}
+
+// ParallelBlockReader is a reader for reading OSMData blocks from an OSM
+// Planet protobuf file, which inflates and parses blocks using multiple
+// goroutines. Blocks are returned in the same order as they appear in the
+// file.
+type ParallelBlockReader struct {
+	rr      *rawBlockReader
+	jobs    chan *parallelJob // jobs waiting for a worker
+	ordered chan *parallelJob // jobs in file order
+	closed  chan struct{}
+	once    sync.Once
+	what    What
+	err     error
+}
+
+// NewParallelBlockReader returns a reader for reading OSMData blocks from an
+// OSM Planet protobuf file using the provided number of worker goroutines.
+// When workers is less than one, runtime.NumCPU() is used.
+// No more than workers*2 blocks are held in memory at any time.
+// The reader must be closed when it's no longer needed.
+func NewParallelBlockReader(r io.Reader, workers int) *ParallelBlockReader {
+	return NewParallelBlockReaderWhat(r, workers, Everything)
+}
+
+// NewParallelBlockReaderWhat is like NewParallelBlockReader, but the blocks
+// are only parsed for what is needed, such as Ways or Everything|Metadata.
+func NewParallelBlockReaderWhat(r io.Reader, workers int, what What,
+) *ParallelBlockReader {
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+	pr := &ParallelBlockReader{
+		rr:      newRawBlockReader(r),
+		jobs:    make(chan *parallelJob, workers),
+		ordered: make(chan *parallelJob, workers*2),
+		closed:  make(chan struct{}),
+		what:    what,
+	}
+	for i := 0; i < workers; i++ {
+		go pr.work()
+	}
+	go pr.feed()
+	return pr
+}
+
+// feed reads the raw blocks and hands them off to the workers.
+func (r *ParallelBlockReader) feed() {
+	defer func() {
+		close(r.jobs)
+		close(r.ordered)
+	}()
+	for {
+		job := &parallelJob{done: make(chan struct{})}
+		for {
+			nn, rblock, err := r.rr.ReadBlock()
+			if err != nil {
+				job.err = err
+				close(job.done)
+				select {
+				case r.ordered <- job:
+				case <-r.closed:
+				}
+				return
+			}
+			job.n += nn
+			if rblock.Type == "OSMData" {
+				job.data = rblock.Data
+				break
+			}
+		}
+		select {
+		case r.ordered <- job:
+		case <-r.closed:
+			return
+		}
+		select {
+		case r.jobs <- job:
+		case <-r.closed:
+			return
+		}
+	}
+}
+
+// work inflates and parses blocks until there are no more jobs.
+func (r *ParallelBlockReader) work() {
+	for job := range r.jobs {
+		data, err := inflate(job.data)
+		if err == nil {
+			job.block, err = procBlock(r.what, data)
+		}
+		job.data = nil
+		job.err = err
+		close(job.done)
+	}
+}
+
+// ReadBlock reads the next OSMData block.
+// Returns the number of bytes read and the block.
+func (r *ParallelBlockReader) ReadBlock() (n int, block Block, err error) {
+	if r.err != nil {
+		return 0, Block{}, r.err
+	}
+	job, ok := <-r.ordered
+	if !ok {
+		r.err = errReaderClosed
+		return 0, Block{}, r.err
+	}
+	<-job.done
+	if job.err != nil {
+		r.err = job.err
+		return 0, Block{}, r.err
+	}
+	return job.n, job.block, nil
+}
+
+// Close stops all background goroutines. Any blocks that have not yet been
+// read are discarded. It does not wait for the goroutines to exit, as one may
+// be blocked reading from the underlying reader, such as a download that is
+// still in progress. That goroutine exits when its read returns.
+func (r *ParallelBlockReader) Close() error {
+	r.once.Do(func() {
+		close(r.closed)
+		// drain so that the feeder and workers can exit
+		go func() {
+			for range r.ordered {
+			}
+		}()
+		if r.err == nil {
+			r.err = errReaderClosed
+		}
+	})
+	return nil
+}
@@ -5,7 +5,6 @@
 package osmfile
 
 import (
-	"errors"
 	"fmt"
 	"io"
 	"unsafe"
@@ -26,6 +25,21 @@ const (
 	Relations       // for processing all relations
 )
 
+// Metadata may be combined with any of the What options, such as
+// Ways|Metadata, to also decode the version, timestamp, changeset, and user
+// information of each node, way, and relation.
+const Metadata What = 1 << 8
+
+// kind returns the What option without the Metadata flag.
+func (what What) kind() What {
+	return what &^ Metadata
+}
+
+// meta returns true if the Metadata flag is set.
+func (what What) meta() bool {
+	return what&Metadata != 0
+}
+
 func procBlock(what What, data []byte) (Block, error) {
 	block := Block{
 		granularity:     100,
@@ -40,24 +54,25 @@ func procBlock(what What, data []byte) (Block, error) {
 		case 1:
 			stringTable = f.Data()
 		case 2:
-			if what == DataKinds {
+			if what.kind() == DataKinds {
 				var perr error
 				block.dataKind, perr =
 					onlyDetectPrimativeDataKind(what, f.Data())
 				if perr != nil {
 					return perr
 				}
-			} else if what != Strings {
+			} else if what.kind() != Strings {
 				primativeGroups = append(primativeGroups, f.Data())
 			}
+		// These are plain int32 and int64 fields, not zigzag encoded.
 		case 17:
-			block.granularity = f.Int64()
+			block.granularity = int64(f.Uint64())
 		case 18:
-			block.dateGranularity = f.Int64()
+			block.dateGranularity = int64(f.Uint64())
 		case 19:
-			block.latOffset = f.Int64()
+			block.latOffset = int64(f.Uint64())
 		case 20:
-			block.lonOffset = f.Int64()
+			block.lonOffset = int64(f.Uint64())
 		default:
 			return fmt.Errorf("unsupported field: %d", f.Num())
 		}
@@ -66,7 +81,7 @@ func procBlock(what What, data []byte) (Block, error) {
 	if err != nil {
 		return Block{}, err
 	}
-	if what != DataKinds {
+	if what.kind() != DataKinds {
 		if err := procStringTable(what, stringTable, &block); err != nil {
 			return Block{}, err
 		}
@@ -84,7 +99,7 @@ func onlyDetectPrimativeDataKind(what What, data []byte) (int, error) {
 	dataKind := -1
 	err := pbf.ForEachField(data, func(f pbf.Field) error {
 		switch f.Num() {
-		case 2:
+		case 1, 2:
 			dataKind = 0
 			return io.EOF
 		case 3:
@@ -128,6 +143,7 @@ func procStringTable(what What, data []byte, block *Block) error {
 		return nil
 	})
 	block.stringsOne = *(*string)(unsafe.Pointer(&stringsOneBytes))
+	block.lookup = new(stringLookup)
 	return nil
 }
 
@@ -135,20 +151,23 @@ func procPrimativeGroup(what What, data []byte, block *Block) error {
 	return pbf.ForEachField(data, func(f pbf.Field) error {
 		switch f.Num() {
 		case 1:
-			return errors.New("plain node pbf type not supported")
+			block.dataKind = 0
+			if what.kind() == Everything || what.kind() == Nodes {
+				return procNode(what, f.Data(), block)
+			}
 		case 2:
 			block.dataKind = 0
-			if what == Everything || what == Nodes {
+			if what.kind() == Everything || what.kind() == Nodes {
 				procDenseNodes(what, f.Data(), block)
 			}
 		case 3:
 			block.dataKind = 1
-			if what == Everything || what == Ways {
+			if what.kind() == Everything || what.kind() == Ways {
 				procWay(what, f.Data(), block)
 			}
 		case 4:
 			block.dataKind = 2
-			if what == Everything || what == Relations {
+			if what.kind() == Everything || what.kind() == Relations {
 				procRelation(what, f.Data(), block)
 			}
 		case 5:
@@ -190,6 +209,13 @@ func procDenseNodes(what What, data []byte, block *Block) error {
 	}
 	nodes := make([]blockNode, numNodes)
 	nodeStrings := make([]uint32, numStrings)
+	var infos []blockInfo
+	if what.meta() {
+		infos = make([]blockInfo, numNodes)
+		for i := range infos {
+			infos[i].visible = true
+		}
+	}
 	var idAdder int64
 	var latAdder int64
 	var lonAdder int64
@@ -205,6 +231,10 @@ func procDenseNodes(what What, data []byte, block *Block) error {
 				i++
 				return nil
 			})
+		case 5:
+			if infos != nil {
+				err = procDenseInfo(f.Data(), infos)
+			}
 		case 8:
 			err = f.ForEachPackedInt64(func(x int64) error {
 				latAdder += x
@@ -248,11 +278,191 @@ func procDenseNodes(what What, data []byte, block *Block) error {
 	if err != nil {
 		return err
 	}
+	// string positions are relative to this group, make them relative to
+	// the block.
+	if base := uint32(len(block.nodeStrings)); base > 0 {
+		for i := range nodes {
+			nodes[i].sset += base
+			nodes[i].send += base
+		}
+	}
 	block.nodes = append(block.nodes, nodes...)
 	block.nodeStrings = append(block.nodeStrings, nodeStrings...)
+	block.nodeInfos = append(block.nodeInfos, infos...)
 	return nil
 }
 
+func procNode(what What, data []byte, block *Block) error {
+	//
+	// message Node {
+	// 	required sint64 id = 1;
+	// 	// Parallel arrays.
+	// 	repeated uint32 keys = 2 [packed = true];
+	// 	repeated uint32 vals = 3 [packed = true];
+	//
+	// 	optional Info info = 4;
+	//
+	// 	required sint64 lat = 8;
+	// 	required sint64 lon = 9;
+	// }
+	//
+	var node blockNode
+	info := blockInfo{visible: true}
+	node.sset = uint32(len(block.nodeStrings))
+	strValIdx := len(block.nodeStrings) + 1
+	err := pbf.ForEachField(data, func(f pbf.Field) error {
+		switch f.Num() {
+		case 1:
+			node.id = f.Int64()
+		case 2:
+			err := f.ForEachPackedUint64(func(x uint64) error {
+				block.nodeStrings = append(block.nodeStrings, uint32(x), 0)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		case 3:
+			err := f.ForEachPackedUint64(func(x uint64) error {
+				block.nodeStrings[strValIdx] = uint32(x)
+				strValIdx += 2
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		case 4:
+			if what.meta() {
+				var err error
+				info, err = procInfo(f.Data())
+				if err != nil {
+					return err
+				}
+			}
+		case 8:
+			node.lat = .000000001 * (float64)(block.latOffset+
+				(block.granularity*f.Int64()))
+		case 9:
+			node.lon = .000000001 * (float64)(block.lonOffset+
+				(block.granularity*f.Int64()))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	node.send = uint32(len(block.nodeStrings))
+	block.nodes = append(block.nodes, node)
+	if what.meta() {
+		block.nodeInfos = append(block.nodeInfos, info)
+	}
+	return nil
+}
+
+func procDenseInfo(data []byte, infos []blockInfo) error {
+	//
+	// message DenseInfo {
+	// 	repeated int32 version = 1 [packed = true]; // NOT DELTA CODED
+	// 	repeated sint64 timestamp = 2 [packed = true]; // DELTA coded
+	// 	repeated sint64 changeset = 3 [packed = true]; // DELTA coded
+	// 	repeated sint32 uid = 4 [packed = true]; // DELTA coded
+	// 	repeated sint32 user_sid = 5 [packed = true]; // DELTA coded
+	// 	repeated bool visible = 6 [packed = true];
+	// }
+	//
+	return pbf.ForEachField(data, func(f pbf.Field) error {
+		var i int
+		var adder int64
+		switch f.Num() {
+		case 1:
+			return f.ForEachPackedUint64(func(x uint64) error {
+				if i < len(infos) {
+					infos[i].version = int32(x)
+				}
+				i++
+				return nil
+			})
+		case 2:
+			return f.ForEachPackedInt64(func(x int64) error {
+				adder += x
+				if i < len(infos) {
+					infos[i].timestamp = adder
+				}
+				i++
+				return nil
+			})
+		case 3:
+			return f.ForEachPackedInt64(func(x int64) error {
+				adder += x
+				if i < len(infos) {
+					infos[i].changeset = adder
+				}
+				i++
+				return nil
+			})
+		case 4:
+			return f.ForEachPackedInt64(func(x int64) error {
+				adder += x
+				if i < len(infos) {
+					infos[i].uid = int32(adder)
+				}
+				i++
+				return nil
+			})
+		case 5:
+			return f.ForEachPackedInt64(func(x int64) error {
+				adder += x
+				if i < len(infos) {
+					infos[i].userSid = uint32(adder)
+				}
+				i++
+				return nil
+			})
+		case 6:
+			return f.ForEachPackedUint64(func(x uint64) error {
+				if i < len(infos) {
+					infos[i].visible = x != 0
+				}
+				i++
+				return nil
+			})
+		}
+		return nil
+	})
+}
+
+func procInfo(data []byte) (blockInfo, error) {
+	//
+	// message Info {
+	// 	optional int32 version = 1 [default = -1];
+	// 	optional int64 timestamp = 2;
+	// 	optional int64 changeset = 3;
+	// 	optional int32 uid = 4;
+	// 	optional uint32 user_sid = 5;
+	// 	optional bool visible = 6;
+	// }
+	//
+	info := blockInfo{visible: true}
+	err := pbf.ForEachField(data, func(f pbf.Field) error {
+		switch f.Num() {
+		case 1:
+			info.version = int32(f.Uint64())
+		case 2:
+			info.timestamp = int64(f.Uint64())
+		case 3:
+			info.changeset = int64(f.Uint64())
+		case 4:
+			info.uid = int32(f.Uint64())
+		case 5:
+			info.userSid = uint32(f.Uint64())
+		case 6:
+			info.visible = f.Uint64() != 0
+		}
+		return nil
+	})
+	return info, err
+}
+
 func procWay(what What, data []byte, block *Block) error {
 	//
 	// message Way {
@@ -264,12 +474,18 @@ func procWay(what What, data []byte, block *Block) error {
 	// 	optional Info info = 4;
 	//
 	// 	repeated sint64 refs = 8 [packed = true];  // DELTA coded
+	//
+	// 	// Optional infos for the LocationsOnWays feature.
+	// 	repeated sint64 lat = 9 [packed = true];  // DELTA coded
+	// 	repeated sint64 lon = 10 [packed = true]; // DELTA coded
 	// }
 	//
 
 	var way blockWay
+	info := blockInfo{visible: true}
 	way.sset = uint32(len(block.wayStrings))
 	way.rset = uint32(len(block.wayRefs))
+	way.lset = uint32(len(block.wayLats))
 	strValIdx := len(block.wayStrings) + 1
 	err := pbf.ForEachField(data, func(f pbf.Field) error {
 		switch f.Num() {
@@ -292,6 +508,14 @@ func procWay(what What, data []byte, block *Block) error {
 			if err != nil {
 				return err
 			}
+		case 4:
+			if what.meta() {
+				var err error
+				info, err = procInfo(f.Data())
+				if err != nil {
+					return err
+				}
+			}
 		case 8:
 			var refAdder int64
 			err := f.ForEachPackedInt64(func(x int64) error {
@@ -302,6 +526,28 @@ func procWay(what What, data []byte, block *Block) error {
 			if err != nil {
 				return err
 			}
+		case 9:
+			var latAdder int64
+			err := f.ForEachPackedInt64(func(x int64) error {
+				latAdder += x
+				block.wayLats = append(block.wayLats, .000000001*
+					(float64)(block.latOffset+(block.granularity*latAdder)))
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		case 10:
+			var lonAdder int64
+			err := f.ForEachPackedInt64(func(x int64) error {
+				lonAdder += x
+				block.wayLons = append(block.wayLons, .000000001*
+					(float64)(block.lonOffset+(block.granularity*lonAdder)))
+				return nil
+			})
+			if err != nil {
+				return err
+			}
 		}
 		return nil
 	})
@@ -310,7 +556,18 @@ func procWay(what What, data []byte, block *Block) error {
 	}
 	way.send = uint32(len(block.wayStrings))
 	way.rend = uint32(len(block.wayRefs))
+	// keep the lats and lons aligned, even for malformed ways
+	for len(block.wayLons) < len(block.wayLats) {
+		block.wayLons = append(block.wayLons, 0)
+	}
+	for len(block.wayLats) < len(block.wayLons) {
+		block.wayLats = append(block.wayLats, 0)
+	}
+	way.lend = uint32(len(block.wayLats))
 	block.ways = append(block.ways, way)
+	if what.meta() {
+		block.wayInfos = append(block.wayInfos, info)
+	}
 	return nil
 }
 
@@ -337,6 +594,7 @@ func procRelation(what What, data []byte, block *Block) error {
 	// }
 	//
 	var relation blockRelation
+	info := blockInfo{visible: true}
 	relation.sset = uint32(len(block.relationStrings))
 	relation.mset = uint32(len(block.relationMemberRefs))
 	strValIdx := len(block.relationStrings) + 1
@@ -362,6 +620,14 @@ func procRelation(what What, data []byte, block *Block) error {
 			if err != nil {
 				return err
 			}
+		case 4:
+			if what.meta() {
+				var err error
+				info, err = procInfo(f.Data())
+				if err != nil {
+					return err
+				}
+			}
 		case 8:
 			err := f.ForEachPackedUint64(func(x uint64) error {
 				block.relationMemberRoles = append(block.relationMemberRoles,
@@ -400,5 +666,8 @@ func procRelation(what What, data []byte, block *Block) error {
 	relation.send = uint32(len(block.relationStrings))
 	relation.mend = uint32(len(block.relationMemberRefs))
 	block.relations = append(block.relations, relation)
+	if what.meta() {
+		block.relationInfos = append(block.relationInfos, info)
+	}
 	return nil
 }
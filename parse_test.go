@@ -0,0 +1,76 @@
+// Copyright 2021 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package osmfile
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/tidwall/osmfile/internal/pbf"
+)
+
+// explicitBlock returns a block with one dense node, which sets the
+// granularity, date granularity, and offset fields explicitly, like osmosis.
+func explicitBlock() []byte {
+	var st []byte
+	st = pbf.AppendString(st, 1, "")
+	st = pbf.AppendString(st, 1, "bob")
+
+	var info []byte
+	info = pbf.AppendPackedUint64(info, 1, []uint64{2})
+	info = pbf.AppendPackedInt64(info, 2, []int64{1600000000})
+	info = pbf.AppendPackedInt64(info, 3, []int64{99})
+	info = pbf.AppendPackedInt64(info, 4, []int64{7})
+	info = pbf.AppendPackedInt64(info, 5, []int64{1})
+
+	var dense []byte
+	dense = pbf.AppendPackedInt64(dense, 1, []int64{10})
+	dense = pbf.AppendBytes(dense, 5, info)
+	dense = pbf.AppendPackedInt64(dense, 8, []int64{1000})
+	dense = pbf.AppendPackedInt64(dense, 9, []int64{-2000})
+
+	var group []byte
+	group = pbf.AppendBytes(group, 2, dense)
+
+	lonOffset := int64(-2000000000)
+	var data []byte
+	data = pbf.AppendBytes(data, 1, st)
+	data = pbf.AppendBytes(data, 2, group)
+	data = pbf.AppendUint64(data, 17, 1000)
+	data = pbf.AppendUint64(data, 18, 1000)
+	data = pbf.AppendUint64(data, 19, 1000000000)
+	data = pbf.AppendUint64(data, 20, uint64(lonOffset))
+	return data
+}
+
+func TestExplicitGranularity(t *testing.T) {
+	block, err := procBlock(Everything|Metadata, explicitBlock())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.NumNodes() != 1 {
+		t.Fatalf("expected 1 node, got %d", block.NumNodes())
+	}
+	n := block.NodeAt(0)
+	if n.ID() != 10 {
+		t.Fatalf("expected id 10, got %d", n.ID())
+	}
+	if lat := n.Lat(); math.Abs(lat-1.001) > 1e-9 {
+		t.Fatalf("expected lat 1.001, got %v", lat)
+	}
+	if lon := n.Lon(); math.Abs(lon+2.002) > 1e-9 {
+		t.Fatalf("expected lon -2.002, got %v", lon)
+	}
+	if ts := n.Timestamp(); !ts.Equal(time.Unix(1600000000, 0)) {
+		t.Fatalf("expected timestamp %v, got %v", time.Unix(1600000000, 0),
+			ts)
+	}
+	if n.Version() != 2 || n.Changeset() != 99 || n.UID() != 7 ||
+		n.User() != "bob" {
+		t.Fatalf("unexpected metadata: %d %d %d %q", n.Version(),
+			n.Changeset(), n.UID(), n.User())
+	}
+}
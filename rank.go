@@ -0,0 +1,154 @@
+// Copyright 2021 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package osmfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rankSampleSize is the number of bytes fetched to measure the throughput.
+// A short sample is mostly limited by the TCP slow start, so it needs to be
+// a few megabytes.
+const rankSampleSize = 4 * 1024 * 1024
+
+// MirrorRank is the measured health of a mirror.
+type MirrorRank struct {
+	URL        string        // url of the planet file on the mirror
+	Latency    time.Duration // duration of a HEAD request
+	Throughput float64       // bytes per second of a ranged GET
+}
+
+// RankMirrors measures the mirrors in AllMirrors that are hosting the planet
+// file for the provided name, and returns them ordered best-first. Each
+// mirror is measured by the latency of a HEAD request and the throughput of
+// a 4MB ranged GET, from the first byte of the body. The mirrors are ranked
+// by the estimated time of downloading the file, which is the latency plus
+// the file size over the throughput. The throughput of such a short sample
+// is only an estimate, and favors mirrors that are close by. Mirrors with a
+// file size that is different than the one on the primary OSM server are left
+// out, as they're likely still syncing. The options may be nil.
+func RankMirrors(name string, opts *DownloadOptions) ([]MirrorRank, error) {
+	client := opts.client(time.Second * 15)
+	ctx := opts.context()
+	size, _, err := headSize(ctx, client, opts, opts.primaryURL()+name)
+	if err != nil {
+		return nil, err
+	}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var ranks []MirrorRank
+	for _, mirror := range AllMirrors {
+		wg.Add(1)
+		go func(url string) {
+			defer wg.Done()
+			rank, err := rankMirror(ctx, client, opts, url, size)
+			if err == nil {
+				mu.Lock()
+				ranks = append(ranks, rank)
+				mu.Unlock()
+			}
+		}(mirror + name)
+	}
+	wg.Wait()
+	if len(ranks) == 0 {
+		return nil, errors.New("no mirrors found")
+	}
+	sort.Slice(ranks, func(i, j int) bool {
+		return ranks[i].cost(size) < ranks[j].cost(size)
+	})
+	return ranks, nil
+}
+
+// cost returns the estimated time in seconds of downloading a file of the
+// provided size from the mirror.
+func (r MirrorRank) cost(size int64) float64 {
+	return r.Latency.Seconds() + float64(size)/r.Throughput
+}
+
+// headSize returns the content length of the url and the duration of the
+// HEAD request.
+func headSize(ctx context.Context, client *http.Client, opts *DownloadOptions,
+	url string,
+) (size int64, latency time.Duration, err error) {
+	req, err := opts.newRequest(ctx, "HEAD", url)
+	if err != nil {
+		return 0, 0, err
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	resp.Body.Close()
+	latency = time.Since(start)
+	if resp.StatusCode != 200 {
+		return 0, 0, newHTTPError(resp)
+	}
+	size, err = strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	return size, latency, nil
+}
+
+func rankMirror(ctx context.Context, client *http.Client,
+	opts *DownloadOptions, url string, size int64,
+) (MirrorRank, error) {
+	msize, latency, err := headSize(ctx, client, opts, url)
+	if err != nil {
+		return MirrorRank{}, err
+	}
+	if msize != size {
+		return MirrorRank{}, fmt.Errorf("size mismatch: %d != %d", msize, size)
+	}
+	sample := int64(rankSampleSize)
+	if sample > size {
+		sample = size
+	}
+	req, err := opts.newRequest(ctx, "GET", url)
+	if err != nil {
+		return MirrorRank{}, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", sample-1))
+	resp, err := client.Do(req)
+	if err != nil {
+		return MirrorRank{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 206 {
+		// ranged requests are needed for resuming and segments
+		return MirrorRank{}, newHTTPError(resp)
+	}
+	// The throughput is measured from the first byte of the body, so that
+	// the latency of the request is not counted twice.
+	body := io.LimitReader(resp.Body, sample)
+	first := make([]byte, 1)
+	if _, err := io.ReadFull(body, first); err != nil {
+		return MirrorRank{}, err
+	}
+	start := time.Now()
+	n, err := io.Copy(ioutil.Discard, body)
+	if err != nil {
+		return MirrorRank{}, err
+	}
+	elapsed := time.Since(start)
+	if n != sample-1 {
+		return MirrorRank{}, io.ErrUnexpectedEOF
+	}
+	return MirrorRank{
+		URL:        url,
+		Latency:    latency,
+		Throughput: float64(n) / elapsed.Seconds(),
+	}, nil
+}
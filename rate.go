@@ -0,0 +1,117 @@
+// Copyright 2021 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package osmfile
+
+import (
+	"time"
+)
+
+// speedWindow is the duration over which the current speed is measured.
+const speedWindow = time.Second * 2
+
+// rateState is the rate limit and transfer rate of a download. It's guarded
+// by the dlfut lock.
+type rateState struct {
+	limit       int64     // bytes per second, zero for no limit
+	tokens      float64   // bytes that may be transferred without waiting
+	refilled    time.Time // last time that the tokens were refilled
+	start       time.Time // start of the download
+	transferred int64     // bytes transferred since the start
+	window      time.Time // start of the current speed window
+	windowBytes int64     // bytes transferred in the current speed window
+	speed       float64   // speed of the previous window
+}
+
+// SetRateLimit sets the maximum transfer rate of the download in bytes per
+// second, which takes effect immediately. Zero means no limit.
+func (dl *dlfut) SetRateLimit(bytesPerSec int64) {
+	dl.cond.L.Lock()
+	defer dl.cond.L.Unlock()
+	if bytesPerSec < 0 {
+		bytesPerSec = 0
+	}
+	dl.rate.limit = bytesPerSec
+	if dl.rate.tokens > float64(bytesPerSec) {
+		dl.rate.tokens = float64(bytesPerSec)
+	}
+}
+
+// received records that n bytes were transferred. The caller must hold the
+// dlfut lock.
+func (dl *dlfut) received(n int) {
+	now := time.Now()
+	dl.rate.transferred += int64(n)
+	dl.rate.windowBytes += int64(n)
+	if elapsed := now.Sub(dl.rate.window); elapsed >= speedWindow {
+		dl.rate.speed = float64(dl.rate.windowBytes) / elapsed.Seconds()
+		dl.rate.window = now
+		dl.rate.windowBytes = 0
+	}
+}
+
+// throttle waits until n more bytes may be transferred under the rate limit.
+// Concurrent transfers share the same limit.
+func (dl *dlfut) throttle(n int) error {
+	dl.cond.L.Lock()
+	limit := dl.rate.limit
+	if limit == 0 {
+		dl.cond.L.Unlock()
+		return nil
+	}
+	now := time.Now()
+	if !dl.rate.refilled.IsZero() {
+		// allow for bursts up to one second
+		dl.rate.tokens += now.Sub(dl.rate.refilled).Seconds() * float64(limit)
+		if dl.rate.tokens > float64(limit) {
+			dl.rate.tokens = float64(limit)
+		}
+	}
+	dl.rate.refilled = now
+	dl.rate.tokens -= float64(n)
+	tokens := dl.rate.tokens
+	dl.cond.L.Unlock()
+	if tokens >= 0 {
+		return nil
+	}
+	wait := time.Duration(-tokens / float64(limit) * float64(time.Second))
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-dl.ctx.Done():
+		return dl.ctx.Err()
+	}
+}
+
+// rateStatus fills the transfer rate fields of the status. The caller must
+// hold the dlfut lock.
+func (dl *dlfut) rateStatus(status *DownloadStatus) {
+	if dl.rate.start.IsZero() {
+		return
+	}
+	status.StartTime = dl.rate.start
+	now := time.Now()
+	if elapsed := now.Sub(dl.rate.start); elapsed > 0 {
+		status.AverageSpeed = float64(dl.rate.transferred) / elapsed.Seconds()
+	}
+	status.Speed = dl.rate.speed
+	if elapsed := now.Sub(dl.rate.window); elapsed >= speedWindow {
+		// no data for a while, so the speed is dropping
+		status.Speed = float64(dl.rate.windowBytes) / elapsed.Seconds()
+	}
+	if dl.done {
+		status.Speed = 0
+		return
+	}
+	speed := status.Speed
+	if speed == 0 {
+		speed = status.AverageSpeed
+	}
+	if speed > 0 && dl.size > dl.downloaded {
+		status.ETA = time.Duration(float64(dl.size-dl.downloaded) / speed *
+			float64(time.Second))
+	}
+}
@@ -16,9 +16,11 @@ import (
 )
 
 type rawBlockReader struct {
-	r   io.Reader
-	err error
-	pos int64
+	r    io.Reader
+	err  error
+	pos  int64
+	size int64 // last known size of a seekable reader
+	pipe bool  // the reader is an io.Seeker that cannot seek, such as stdin
 }
 
 func newRawBlockReader(r io.Reader) *rawBlockReader {
@@ -31,14 +33,42 @@ type rawBlock struct {
 }
 
 func (r *rawBlockReader) ReadBlock() (n int, block rawBlock, err error) {
+	bpos := r.pos
+	btype, bsize, err := r.readBlobHeader()
+	if err != nil {
+		return 0, rawBlock{}, err
+	}
+	bdata, err := r.readBlobData(bsize)
+	if err != nil {
+		return 0, rawBlock{}, err
+	}
+	return int(r.pos - bpos), rawBlock{Type: btype, Data: bdata}, nil
+}
+
+// readBlobData reads the blob data that follows a blob header.
+func (r *rawBlockReader) readBlobData(bsize int) ([]byte, error) {
 	if r.err != nil {
-		return 0, rawBlock{}, r.err
+		return nil, r.err
+	}
+	bdata := make([]byte, bsize)
+	if _, err := io.ReadFull(r.r, bdata); err != nil {
+		r.err = err
+		return nil, r.err
+	}
+	r.pos += int64(bsize)
+	return bdata, nil
+}
+
+// readBlobHeader reads the next blob header, leaving the reader positioned at
+// the start of the blob data.
+func (r *rawBlockReader) readBlobHeader() (btype string, bsize int, err error) {
+	if r.err != nil {
+		return "", 0, r.err
 	}
-	bpos := r.pos
 	var buf [4]byte
 	if _, err := io.ReadFull(r.r, buf[:]); err != nil {
 		r.err = err
-		return 0, rawBlock{}, r.err
+		return "", 0, r.err
 	}
 	r.pos += 4
 
@@ -46,7 +76,7 @@ func (r *rawBlockReader) ReadBlock() (n int, block rawBlock, err error) {
 	hdr := make([]byte, hdrLen)
 	if _, err := io.ReadFull(r.r, hdr); err != nil {
 		r.err = err
-		return 0, rawBlock{}, r.err
+		return "", 0, r.err
 	}
 	r.pos += int64(hdrLen)
 	/*
@@ -56,8 +86,6 @@ func (r *rawBlockReader) ReadBlock() (n int, block rawBlock, err error) {
 			required int32 datasize = 3;
 		}
 	*/
-	var btype string
-	var bsize int
 	err = pbf.ForEachField(hdr, func(f pbf.Field) error {
 		switch f.Num() {
 		case 1:
@@ -69,22 +97,75 @@ func (r *rawBlockReader) ReadBlock() (n int, block rawBlock, err error) {
 	})
 	if err != nil {
 		r.err = err
-		return 0, rawBlock{}, err
+		return "", 0, err
 	}
+	return btype, bsize, nil
+}
 
-	bdata := make([]byte, bsize)
-	if _, err := io.ReadFull(r.r, bdata); err != nil {
+// skipBlobData skips over blob data that follows a blob header. The data is
+// seeked over when the underlying reader is an io.Seeker that can seek.
+func (r *rawBlockReader) skipBlobData(bsize int) error {
+	if r.err != nil {
+		return r.err
+	}
+	if s, ok := r.r.(io.Seeker); ok && !r.pipe {
+		pos, err := s.Seek(int64(bsize), io.SeekCurrent)
+		if err == nil {
+			if err := r.checkSeekPos(s, pos); err != nil {
+				r.err = err
+				return r.err
+			}
+			r.pos += int64(bsize)
+			return nil
+		}
+		// An *os.File for a pipe is an io.Seeker, but it fails without
+		// moving. Read over the data instead, from now on.
+		r.pipe = true
+	}
+	if _, err := io.CopyN(ioutil.Discard, r.r, int64(bsize)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
 		r.err = err
-		return 0, rawBlock{}, r.err
+		return r.err
 	}
 	r.pos += int64(bsize)
-	return int(r.pos - bpos), rawBlock{Type: btype, Data: bdata}, nil
+	return nil
+}
+
+// checkSeekPos makes sure that the data before the seeked position exists.
+func (r *rawBlockReader) checkSeekPos(s io.Seeker, pos int64) error {
+	var err error
+	if pos > r.size {
+		// Seeking past the end of a file does not fail, so make sure that
+		// the data actually exists.
+		r.size, err = s.Seek(0, io.SeekEnd)
+		if err != nil {
+			return err
+		}
+		if _, err := s.Seek(pos, io.SeekStart); err != nil {
+			return err
+		}
+		if pos > r.size {
+			return io.ErrUnexpectedEOF
+		}
+	}
+	return nil
+}
+
+// BlockSource is a source of blocks, such as a BlockReader,
+// ParallelBlockReader, or XMLReader.
+type BlockSource interface {
+	ReadBlock() (n int, block Block, err error)
 }
 
 // BlockReader is a reader for reading OSMData blocks from an OSM Planet
 // protobuf file.
 type BlockReader struct {
-	rr *rawBlockReader
+	rr      *rawBlockReader
+	header  *Header
+	pending *rawBlock // block that was read ahead by Header
+	pendn   int       // bytes read ahead by Header
 }
 
 // NewBlockReader returns a reader for reading OSMData blocks from an OSM Planet
@@ -93,11 +174,79 @@ func NewBlockReader(r io.Reader) *BlockReader {
 	return &BlockReader{rr: newRawBlockReader(r)}
 }
 
+// Header returns the OSMHeader block of the file. It may be called at any
+// time. When the header has not yet been read, the reader advances to it.
+// Returns ErrNoHeader if the file has OSMData prior to an OSMHeader.
+func (r *BlockReader) Header() (Header, error) {
+	for r.header == nil {
+		if r.pending != nil {
+			return Header{}, ErrNoHeader
+		}
+		nn, rblock, err := r.rr.ReadBlock()
+		if err != nil {
+			return Header{}, err
+		}
+		r.pendn += nn
+		switch rblock.Type {
+		case "OSMHeader":
+			if err := r.procHeader(rblock); err != nil {
+				return Header{}, err
+			}
+		case "OSMData":
+			r.pending = &rblock
+		}
+	}
+	return *r.header, nil
+}
+
+func (r *BlockReader) procHeader(rblock rawBlock) error {
+	data, err := inflate(rblock.Data)
+	if err != nil {
+		return err
+	}
+	hdr, err := procHeader(data)
+	if err != nil {
+		return err
+	}
+	r.header = &hdr
+	return nil
+}
+
+// readRawBlock reads the next raw block, including any that were read ahead
+// by Header.
+func (r *BlockReader) readRawBlock() (n int, rblock rawBlock, err error) {
+	if r.pending != nil {
+		n, rblock = r.pendn, *r.pending
+		r.pending, r.pendn = nil, 0
+		return n, rblock, nil
+	}
+	n, rblock, err = r.rr.ReadBlock()
+	if err != nil {
+		return 0, rawBlock{}, err
+	}
+	n += r.pendn
+	r.pendn = 0
+	if rblock.Type == "OSMHeader" && r.header == nil {
+		if err := r.procHeader(rblock); err != nil {
+			return 0, rawBlock{}, err
+		}
+	}
+	return n, rblock, nil
+}
+
 // ReadBlock reads the next OSMData block.
 // Returns the number of bytes read and the block.
 func (r *BlockReader) ReadBlock() (n int, block Block, err error) {
+	return r.ReadBlockWhat(Everything)
+}
+
+// ReadBlockWhat reads the next OSMData block, but only parses what is needed.
+// For example, ReadBlockWhat(Ways) will not decode nodes or relations, and the
+// returned block will only contain the ways.
+// Returns the number of bytes read and the block.
+func (r *BlockReader) ReadBlockWhat(what What) (n int, block Block, err error) {
 	for {
-		nn, rblock, err := r.rr.ReadBlock()
+		nn, rblock, err := r.readRawBlock()
 		if err != nil {
 			return 0, Block{}, err
 		}
@@ -109,7 +258,7 @@ func (r *BlockReader) ReadBlock() (n int, block Block, err error) {
 		if err != nil {
 			return 0, Block{}, err
 		}
-		block, err := procBlock(Everything, data)
+		block, err := procBlock(what, data)
 		if err != nil {
 			return 0, Block{}, err
 		}
@@ -118,17 +267,40 @@ func (r *BlockReader) ReadBlock() (n int, block Block, err error) {
 }
 
 // SkipBlock skips over the next block. Like ReadBlock but faster.
+// The block data is not read when the underlying reader is an io.Seeker, such
+// as an *os.File, making it possible to quickly skip over large sections of a
+// file. Readers that cannot seek, such as a pipe, are read over instead.
 func (r *BlockReader) SkipBlock() (n int, err error) {
+	if r.pending != nil {
+		nn, rblock, _ := r.readRawBlock()
+		n += nn
+		if rblock.Type == "OSMData" {
+			return n, nil
+		}
+	}
 	for {
-		nn, rblock, err := r.rr.ReadBlock()
+		bpos := r.rr.pos
+		btype, bsize, err := r.rr.readBlobHeader()
 		if err != nil {
 			return 0, err
 		}
-		n += nn
-		if rblock.Type != "OSMData" {
-			continue
+		if btype == "OSMHeader" && r.header == nil {
+			// Headers are tiny, go ahead and read it.
+			bdata, err := r.rr.readBlobData(bsize)
+			if err != nil {
+				return 0, err
+			}
+			if err := r.procHeader(rawBlock{btype, bdata}); err != nil {
+				return 0, err
+			}
+		} else if err := r.rr.skipBlobData(bsize); err != nil {
+			return 0, err
+		}
+		n += int(r.rr.pos-bpos) + r.pendn
+		r.pendn = 0
+		if btype == "OSMData" {
+			return n, nil
 		}
-		return n, nil
 	}
 }
 
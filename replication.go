@@ -0,0 +1,301 @@
+// Copyright 2021 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package osmfile
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var replicationPrimary = "https://planet.openstreetmap.org/replication/"
+
+// ReplicationInterval is the interval of replication diffs.
+type ReplicationInterval string
+
+// ReplicationInterval options
+const (
+	Minutely ReplicationInterval = "minute"
+	Hourly   ReplicationInterval = "hour"
+	Daily    ReplicationInterval = "day"
+)
+
+// ReplicationURL returns the url of the replication diffs for the interval
+// on the primary OSM server.
+func ReplicationURL(interval ReplicationInterval) string {
+	return replicationPrimary + string(interval) + "/"
+}
+
+// ReplicationState is the contents of a replication state.txt file.
+type ReplicationState struct {
+	SequenceNumber int64
+	Timestamp      time.Time
+}
+
+// ParseReplicationState parses the contents of a replication state.txt
+// file.
+func ParseReplicationState(data []byte) (ReplicationState, error) {
+	var state ReplicationState
+	var hasSeq bool
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		// values are escaped java properties, such as "2021-03-29T12\:00Z"
+		value := strings.Replace(parts[1], `\`, "", -1)
+		switch parts[0] {
+		case "sequenceNumber":
+			seq, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return ReplicationState{}, err
+			}
+			state.SequenceNumber = seq
+			hasSeq = true
+		case "timestamp":
+			ts, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return ReplicationState{}, err
+			}
+			state.Timestamp = ts
+		}
+	}
+	if !hasSeq {
+		return ReplicationState{}, errors.New("missing sequence number")
+	}
+	return state, nil
+}
+
+// FetchReplicationState fetches the latest replication state from the
+// provided replication url, such as ReplicationURL(Minutely). The options
+// may be nil.
+func FetchReplicationState(replicationURL string, opts *DownloadOptions) (
+	ReplicationState, error,
+) {
+	req, err := opts.newRequest(opts.context(), "GET",
+		strings.TrimSuffix(replicationURL, "/")+"/state.txt")
+	if err != nil {
+		return ReplicationState{}, err
+	}
+	resp, err := opts.client(time.Second * 15).Do(req)
+	if err != nil {
+		return ReplicationState{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return ReplicationState{}, newHTTPError(resp)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return ReplicationState{}, err
+	}
+	return ParseReplicationState(data)
+}
+
+// ReplicationPath returns the path of a diff file relative to the replication
+// url, without the extension. Such as "004/512/345" for sequence 4512345.
+func ReplicationPath(seq int64) string {
+	return fmt.Sprintf("%03d/%03d/%03d", seq/1000000, seq/1000%1000, seq%1000)
+}
+
+// ReplicationMirrors returns a list of replication urls for the interval,
+// from the mirrors in AllMirrors that are also hosting replication diffs.
+// The options may be nil.
+func ReplicationMirrors(interval ReplicationInterval, opts *DownloadOptions,
+) (mirrors []string, err error) {
+	client := opts.client(time.Second * 15)
+	ctx := opts.context()
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for _, mirror := range AllMirrors {
+		if !strings.HasSuffix(mirror, "/pbf/") {
+			continue
+		}
+		url := strings.TrimSuffix(mirror, "pbf/") + "replication/" +
+			string(interval) + "/"
+		wg.Add(1)
+		go func(url string) {
+			defer wg.Done()
+			req, err := opts.newRequest(ctx, "HEAD", url+"state.txt")
+			if err != nil {
+				return
+			}
+			resp, err := client.Do(req)
+			if err == nil {
+				resp.Body.Close()
+				if resp.StatusCode == 200 {
+					mu.Lock()
+					mirrors = append(mirrors, url)
+					mu.Unlock()
+				}
+			}
+		}(url)
+	}
+	wg.Wait()
+	if len(mirrors) == 0 {
+		return nil, errors.New("no mirrors found")
+	}
+	sort.Strings(mirrors)
+	return mirrors, nil
+}
+
+type diffsfut struct {
+	*dlfut
+	paths []string // completed diff files, in sequence order
+}
+
+// DownloadDiffs downloads the replication diffs from the sequence number
+// from to the sequence number to (inclusive), into the dir directory using
+// the replication layout, such as "dir/004/512/345.osc.gz". When to is less
+// than one, the sequence number of the latest replication state is used.
+// The diffs are downloaded one at a time. Files that were already downloaded
+// are skipped, and partially downloaded files are resumed.
+//
+// The Reader of the returned Downloader reads all of the diffs as they
+// complete, as one concatenated gzip stream which can be read by
+// ReadChanges.
+//
+// The options may be nil. Each diff is retried using the Retry policy of the
+// options. The Checksum, Segments, and Mirrors options are not used, as
+// they're for planet files.
+func DownloadDiffs(replicationURL, dir string, from, to int64,
+	opts *DownloadOptions,
+) Downloader {
+	replicationURL = strings.TrimSuffix(replicationURL, "/") + "/"
+	var dopts DownloadOptions
+	if opts != nil {
+		dopts = *opts
+	}
+	dopts.Checksum = false
+	dopts.Segments = 0
+	dopts.Mirrors = nil
+	dl := &diffsfut{dlfut: newDlfut(dopts.context())}
+	dl.SetRateLimit(dopts.RateLimit)
+	go func() {
+		defer func() {
+			dl.cancel()
+			dl.cond.L.Lock()
+			dl.done = true
+			dl.cond.Broadcast()
+			dl.cond.L.Unlock()
+		}()
+		err := dl.download(replicationURL, dir, from, to, &dopts)
+		if err != nil {
+			dl.cond.L.Lock()
+			if dl.err == nil {
+				dl.err = err
+			}
+			dl.cond.Broadcast()
+			dl.cond.L.Unlock()
+		}
+	}()
+	return dl
+}
+
+func (dl *diffsfut) download(replicationURL, dir string, from, to int64,
+	opts *DownloadOptions,
+) error {
+	if to < 1 {
+		state, err := FetchReplicationState(replicationURL, opts)
+		if err != nil {
+			return err
+		}
+		to = state.SequenceNumber
+	}
+	for seq := from; seq <= to; seq++ {
+		dl.cond.L.Lock()
+		err := dl.err
+		dl.cond.L.Unlock()
+		if err != nil {
+			return err
+		}
+		name := ReplicationPath(seq) + ".osc.gz"
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			return err
+		}
+		for attempts := 1; ; attempts++ {
+			err = download(replicationURL+name, path, dl.dlfut, opts)
+			if err == nil || !dl.retry(opts.Retry, attempts, err) {
+				break
+			}
+		}
+		if err != nil {
+			return err
+		}
+		dl.cond.L.Lock()
+		dl.paths = append(dl.paths, path)
+		dl.cond.Broadcast()
+		dl.cond.L.Unlock()
+	}
+	return nil
+}
+
+type diffsReader struct {
+	dl  *diffsfut
+	idx int
+	f   *os.File
+}
+
+func (rd *diffsReader) Read(p []byte) (int, error) {
+	for {
+		if rd.f == nil {
+			rd.dl.cond.L.Lock()
+			for rd.idx == len(rd.dl.paths) && !rd.dl.done &&
+				rd.dl.err == nil {
+				rd.dl.cond.Wait()
+			}
+			if rd.idx == len(rd.dl.paths) {
+				err := rd.dl.err
+				rd.dl.cond.L.Unlock()
+				if err == nil {
+					err = io.EOF
+				}
+				return 0, err
+			}
+			path := rd.dl.paths[rd.idx]
+			rd.dl.cond.L.Unlock()
+			f, err := os.Open(path)
+			if err != nil {
+				return 0, err
+			}
+			rd.f = f
+			rd.idx++
+		}
+		n, err := rd.f.Read(p)
+		if err == io.EOF {
+			rd.f.Close()
+			rd.f = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (rd *diffsReader) Close() error {
+	if rd.f != nil {
+		return rd.f.Close()
+	}
+	return nil
+}
+
+func (dl *diffsfut) Reader() io.ReadCloser {
+	return &diffsReader{dl: dl}
+}
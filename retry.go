@@ -0,0 +1,209 @@
+// Copyright 2021 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package osmfile
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errCorrupt        = errors.New("corrupt: too much data written")
+	errStalled        = errors.New("download stalled")
+	errAttemptTimeout = errors.New("download attempt timed out")
+)
+
+// RetryPolicy is the policy for retrying a failed download. Each attempt
+// resumes the download from where the previous one left off.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the first.
+	// Default is five.
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry, which doubles for
+	// each retry after that. Default is one second.
+	InitialBackoff time.Duration
+	// MaxBackoff is the maximum wait between retries. Default is one minute.
+	MaxBackoff time.Duration
+	// AttemptTimeout is the maximum duration of a single attempt. Zero means
+	// no limit.
+	AttemptTimeout time.Duration
+	// StallTimeout aborts an attempt when no data is received for the
+	// duration. Zero means no limit.
+	StallTimeout time.Duration
+}
+
+// maxAttempts returns the maximum number of attempts.
+func (p *RetryPolicy) maxAttempts() int {
+	if p.MaxAttempts <= 0 {
+		return 5
+	}
+	return p.MaxAttempts
+}
+
+// backoff returns the wait before the retry, starting at one.
+func (p *RetryPolicy) backoff(retry int) time.Duration {
+	wait, max := p.InitialBackoff, p.MaxBackoff
+	if wait <= 0 {
+		wait = time.Second
+	}
+	if max <= 0 {
+		max = time.Minute
+	}
+	for i := 1; i < retry && wait < max; i++ {
+		wait *= 2
+	}
+	if wait > max {
+		wait = max
+	}
+	return wait
+}
+
+// httpError is an unexpected http response status.
+type httpError struct {
+	status string
+	code   int
+}
+
+func (err *httpError) Error() string {
+	return err.status
+}
+
+func newHTTPError(res *http.Response) error {
+	return &httpError{status: res.Status, code: res.StatusCode}
+}
+
+// retryable returns true if the download may succeed when it's tried again.
+// Only timeouts, stalls, failed connections, truncated responses, and server
+// errors are retried. Errors such as an unsupported scheme, a bad certificate,
+// a rejected proxy, or an unknown host are not.
+func retryable(err error) bool {
+	var herr *httpError
+	switch {
+	case errors.Is(err, errStalled), errors.Is(err, errAttemptTimeout),
+		errors.Is(err, io.ErrUnexpectedEOF):
+		return true
+	case errors.As(err, &herr):
+		return herr.code >= 500 || herr.code == http.StatusRequestTimeout ||
+			herr.code == http.StatusTooManyRequests
+	}
+	// Every error of an http request is a *url.Error, which is a net.Error,
+	// so look at the error that it wraps instead.
+	var uerr *url.Error
+	if errors.As(err, &uerr) {
+		if uerr.Err == io.EOF {
+			// the server closed the connection without a response
+			return true
+		}
+		err = uerr.Err
+	}
+	var nerr net.Error
+	if errors.As(err, &nerr) && nerr.Timeout() {
+		return true
+	}
+	// failed connections, and resets while reading the response
+	var oerr *net.OpError
+	if errors.As(err, &oerr) && (oerr.Op == "dial" || oerr.Op == "read") {
+		var dnserr *net.DNSError
+		if errors.As(err, &dnserr) && !dnserr.IsTemporary &&
+			!dnserr.IsTimeout {
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// attempt is a single download attempt, which is canceled when it takes too
+// long or stalls.
+type attempt struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	stall   time.Duration
+	timer   *time.Timer
+	stalled int32
+}
+
+func newAttempt(ctx context.Context, policy *RetryPolicy) *attempt {
+	a := new(attempt)
+	a.ctx, a.cancel = context.WithCancel(ctx)
+	if policy == nil {
+		return a
+	}
+	if policy.AttemptTimeout > 0 {
+		a.ctx, a.cancel = context.WithTimeout(a.ctx, policy.AttemptTimeout)
+	}
+	if policy.StallTimeout > 0 {
+		a.stall = policy.StallTimeout
+		a.timer = time.AfterFunc(a.stall, func() {
+			atomic.StoreInt32(&a.stalled, 1)
+			a.cancel()
+		})
+	}
+	return a
+}
+
+// progress is called when data is received, which restarts the stall timer.
+func (a *attempt) progress() {
+	if a.timer != nil {
+		a.timer.Reset(a.stall)
+	}
+}
+
+// pause stops the stall timer until the next progress, such as while the
+// download is throttled.
+func (a *attempt) pause() {
+	if a.timer != nil {
+		a.timer.Stop()
+	}
+}
+
+// err returns the reason that the attempt was canceled, if any, otherwise
+// the provided error.
+func (a *attempt) err(err error) error {
+	if atomic.LoadInt32(&a.stalled) == 1 {
+		return errStalled
+	}
+	if a.ctx.Err() == context.DeadlineExceeded {
+		return errAttemptTimeout
+	}
+	return err
+}
+
+func (a *attempt) done() {
+	if a.timer != nil {
+		a.timer.Stop()
+	}
+	a.cancel()
+}
+
+// retry waits before the next attempt of a failed download. Returns false if
+// the download should not be retried, because it was stopped, the error is
+// permanent, or there are no attempts left.
+func (dl *dlfut) retry(policy *RetryPolicy, attempts int, err error) bool {
+	if policy == nil || !retryable(err) || dl.ctx.Err() != nil ||
+		attempts >= policy.maxAttempts() {
+		return false
+	}
+	timer := time.NewTimer(policy.backoff(attempts))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-dl.ctx.Done():
+	}
+	dl.cond.L.Lock()
+	defer dl.cond.L.Unlock()
+	if dl.err != nil || dl.ctx.Err() != nil {
+		return false
+	}
+	dl.retries++
+	dl.cond.Broadcast()
+	return true
+}
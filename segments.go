@@ -0,0 +1,402 @@
+// Copyright 2021 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package osmfile
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	segmentsMagic = "OSMFSEG1"
+	segmentSize   = 32 * 1024 * 1024
+)
+
+type segment struct {
+	start int64 // offset of the segment in the file
+	end   int64 // offset of the end of the segment (exclusive)
+	done  int64 // number of bytes downloaded
+	tries int   // number of failed attempts
+}
+
+func (seg *segment) complete() bool {
+	return seg.start+seg.done == seg.end
+}
+
+// segmentedDownload is a download of a single file that is split into
+// segments. The segment progress and the dlfut fields are guarded by the
+// dlfut lock.
+type segmentedDownload struct {
+	client *http.Client
+	opts   *DownloadOptions
+	urls   []string
+	path   string
+	size   int64
+	dl     *dlfut
+	f      *os.File
+	segs   []segment
+	first  int   // first incomplete segment
+	err    error // first failed segment, which stops the others
+}
+
+// segmentsPath returns the path of the sidecar file that has the progress of
+// the segments.
+func segmentsPath(path string) string {
+	return path + ".segments"
+}
+
+func newSegments(size int64) []segment {
+	var segs []segment
+	for start := int64(0); start < size; start += segmentSize {
+		end := start + segmentSize
+		if end > size {
+			end = size
+		}
+		segs = append(segs, segment{start: start, end: end})
+	}
+	return segs
+}
+
+// loadSegments returns the progress of the segments from the sidecar file.
+// Returns false if the file does not exist or is for another download.
+func loadSegments(path string, size int64) ([]segment, bool) {
+	data, err := ioutil.ReadFile(segmentsPath(path))
+	if err != nil || len(data) < 16 || string(data[:8]) != segmentsMagic ||
+		int64(binary.LittleEndian.Uint64(data[8:])) != size {
+		return nil, false
+	}
+	segs := newSegments(size)
+	if len(data) != 16+len(segs)*8 {
+		return nil, false
+	}
+	for i := range segs {
+		done := int64(binary.LittleEndian.Uint64(data[16+i*8:]))
+		if done < 0 || done > segs[i].end-segs[i].start {
+			return nil, false
+		}
+		segs[i].done = done
+	}
+	return segs, true
+}
+
+// saveSegments writes the progress of the segments to the sidecar file. The
+// file data is synced first, so the progress never runs ahead of the data.
+func (sd *segmentedDownload) saveSegments() error {
+	sd.dl.cond.L.Lock()
+	data := make([]byte, 16+len(sd.segs)*8)
+	copy(data, segmentsMagic)
+	binary.LittleEndian.PutUint64(data[8:], uint64(sd.size))
+	for i, seg := range sd.segs {
+		binary.LittleEndian.PutUint64(data[16+i*8:], uint64(seg.done))
+	}
+	sd.dl.cond.L.Unlock()
+	if err := sd.f.Sync(); err != nil {
+		return err
+	}
+	tmp := segmentsPath(sd.path) + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, segmentsPath(sd.path))
+}
+
+// downloadSegments downloads the file from the urls, concurrently in
+// segments. Each url must serve the same file and support range requests.
+// It's also used to resume a segmented download that has a sidecar file,
+// even when fewer segments are requested.
+func downloadSegments(client *http.Client, urls []string, path string,
+	size int64, checksum string, dl *dlfut, opts *DownloadOptions,
+) error {
+	sd := &segmentedDownload{
+		client: client, opts: opts, path: path, size: size, dl: dl,
+	}
+	seen := make(map[string]bool)
+	for _, url := range urls {
+		if !seen[url] {
+			seen[url] = true
+			sd.urls = append(sd.urls, url)
+		}
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0666)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sd.f = f
+	start, err := f.Seek(0, 2)
+	if err != nil {
+		return err
+	}
+	segs, ok := loadSegments(path, size)
+	if !ok {
+		if start > size {
+			return errCorrupt
+		}
+		// The file is new, or it was partially downloaded from start to end.
+		segs = newSegments(size)
+		for i := range segs {
+			if start > segs[i].start {
+				segs[i].done = start - segs[i].start
+				if segs[i].done > segs[i].end-segs[i].start {
+					segs[i].done = segs[i].end - segs[i].start
+				}
+			}
+		}
+	}
+	sd.segs = segs
+	var downloaded int64
+	var pending []int
+	for i, seg := range segs {
+		downloaded += seg.done
+		if !seg.complete() {
+			pending = append(pending, i)
+		}
+	}
+	if len(pending) > 0 {
+		// The sidecar must exist before the file is extended to its full
+		// size, otherwise a single stream download would see a complete
+		// file when resuming.
+		if err := sd.saveSegments(); err != nil {
+			return err
+		}
+	}
+	if err := f.Truncate(size); err != nil {
+		return err
+	}
+	dl.cond.L.Lock()
+	dl.path = path
+	dl.size = size
+	dl.downloaded = downloaded
+	sd.advance()
+	dl.cond.Broadcast()
+	dl.cond.L.Unlock()
+
+	if len(pending) > 0 {
+		workers := opts.Segments
+		if workers < 1 {
+			workers = 1
+		}
+		if err := sd.run(pending, workers); err != nil {
+			sd.saveSegments()
+			return err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	err = os.Remove(segmentsPath(path))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if checksum != "" {
+		h := md5.New()
+		_, err := io.Copy(h, io.NewSectionReader(f, 0, size))
+		if err != nil {
+			return err
+		}
+		err = verifyChecksum(path, checksum, h)
+		var cerr *ChecksumError
+		if errors.As(err, &cerr) && downloaded > 0 {
+			// The data from a previous download may be bad, so start over,
+			// otherwise every later download would fail too.
+			if err := dl.restart(path); err != nil {
+				return err
+			}
+			return downloadSegments(client, urls, path, size, checksum, dl,
+				opts)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// advance updates the available data to the end of the first incomplete
+// segment. The caller must hold the dlfut lock.
+func (sd *segmentedDownload) advance() {
+	for sd.first < len(sd.segs) && sd.segs[sd.first].complete() {
+		sd.first++
+	}
+	if sd.first == len(sd.segs) {
+		sd.dl.avail = sd.size
+	} else {
+		seg := sd.segs[sd.first]
+		sd.dl.avail = seg.start + seg.done
+	}
+}
+
+// stopped returns the error that stopped the download, if any. The caller
+// must hold the dlfut lock.
+func (sd *segmentedDownload) stopped() error {
+	if sd.dl.err != nil {
+		return sd.dl.err
+	}
+	return sd.err
+}
+
+// run downloads the pending segments using the provided number of workers.
+// A failed segment is retried on the next url, until it has failed on all of
+// the urls.
+func (sd *segmentedDownload) run(pending []int, workers int) error {
+	queue := make(chan int, len(pending))
+	for _, i := range pending {
+		queue <- i
+	}
+	remaining := len(pending)
+	finished := make(chan struct{})
+	quit := make(chan struct{})
+	errc := make(chan error, workers)
+	fail := func(err error) {
+		sd.dl.cond.L.Lock()
+		if sd.err == nil {
+			sd.err = err
+		}
+		sd.dl.cond.L.Unlock()
+		select {
+		case errc <- err:
+		default:
+		}
+	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for {
+				var i int
+				select {
+				case i = <-queue:
+				case <-finished:
+					return
+				case <-quit:
+					return
+				}
+				url := sd.urls[(i+sd.segs[i].tries)%len(sd.urls)]
+				if err := sd.fetch(url, i); err != nil {
+					sd.dl.cond.L.Lock()
+					stopped := sd.stopped() != nil
+					sd.segs[i].tries++
+					tries := sd.segs[i].tries
+					sd.dl.cond.L.Unlock()
+					if stopped {
+						fail(err)
+						return
+					}
+					if tries >= len(sd.urls) {
+						fail(fmt.Errorf("segment %d: %w", i, err))
+						return
+					}
+					queue <- i
+					continue
+				}
+				sd.dl.cond.L.Lock()
+				remaining--
+				if remaining == 0 {
+					close(finished)
+				}
+				sd.dl.cond.L.Unlock()
+			}
+		}()
+	}
+	// save the progress every few seconds, in case the process is killed
+	ticker := time.NewTicker(time.Second * 5)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-finished:
+			return nil
+		case err := <-errc:
+			close(quit)
+			sd.dl.cond.L.Lock()
+			err = sd.stopped()
+			sd.dl.cond.L.Unlock()
+			return err
+		case <-ticker.C:
+			if err := sd.saveSegments(); err != nil {
+				fail(err)
+			}
+		}
+	}
+}
+
+// fetch downloads the rest of the segment from the url.
+func (sd *segmentedDownload) fetch(url string, i int) error {
+	sd.dl.cond.L.Lock()
+	seg := sd.segs[i]
+	err := sd.stopped()
+	sd.dl.cond.L.Unlock()
+	if err != nil {
+		return err
+	}
+	a := newAttempt(sd.dl.ctx, sd.opts.Retry)
+	defer a.done()
+	off := seg.start + seg.done
+	req, err := sd.opts.newRequest(a.ctx, "GET", url)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, seg.end-1))
+	res, err := sd.client.Do(req)
+	if err != nil {
+		return a.err(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 206 {
+		return newHTTPError(res)
+	}
+	// make sure that the mirror has the same file
+	crange := res.Header.Get("Content-Range")
+	if !strings.HasSuffix(crange, fmt.Sprintf("/%d", sd.size)) ||
+		!strings.HasPrefix(crange, fmt.Sprintf("bytes %d-", off)) {
+		return fmt.Errorf("invalid content range: %q", crange)
+	}
+	packet := make([]byte, sd.opts.bufferSize(64*1024))
+	for off < seg.end {
+		n, err := res.Body.Read(packet)
+		if n > 0 {
+			a.pause()
+			if off+int64(n) > seg.end {
+				return errCorrupt
+			}
+			if _, err := sd.f.WriteAt(packet[:n], off); err != nil {
+				return err
+			}
+			off += int64(n)
+			sd.dl.cond.L.Lock()
+			if err := sd.stopped(); err != nil {
+				sd.dl.cond.L.Unlock()
+				return err
+			}
+			sd.segs[i].done += int64(n)
+			sd.dl.downloaded += int64(n)
+			sd.dl.received(n)
+			if i == sd.first {
+				sd.advance()
+			}
+			sd.dl.cond.Broadcast()
+			sd.dl.cond.L.Unlock()
+			if err := sd.dl.throttle(n); err != nil {
+				return err
+			}
+			a.progress()
+		}
+		if err != nil {
+			if err == io.EOF {
+				if off != seg.end {
+					return io.ErrUnexpectedEOF
+				}
+				break
+			}
+			return a.err(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2021 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package osmfile
+
+import "sync"
+
+// stringLookup maps the strings of a block string table to their index. It's
+// built on first use and shared by all copies of the block.
+type stringLookup struct {
+	once sync.Once
+	m    map[string]uint32
+}
+
+// StringIndex returns the index of the string in the block string table.
+// Tag lookups use this index to compare keys without comparing strings.
+func (b Block) StringIndex(s string) (index int, ok bool) {
+	if b.lookup == nil {
+		for i, str := range b.strings {
+			if str == s {
+				return i, true
+			}
+		}
+		return 0, false
+	}
+	b.lookup.once.Do(func() {
+		b.lookup.m = make(map[string]uint32, len(b.strings))
+		for i := len(b.strings) - 1; i >= 0; i-- {
+			b.lookup.m[b.strings[i]] = uint32(i)
+		}
+	})
+	idx, ok := b.lookup.m[s]
+	return int(idx), ok
+}
+
+func (b Block) tagAt(strs []uint32, index int) (key, value string) {
+	return b.strings[strs[index*2]], b.strings[strs[index*2+1]]
+}
+
+func (b Block) tag(strs []uint32, key string) (value string, ok bool) {
+	kidx, ok := b.StringIndex(key)
+	if !ok {
+		return "", false
+	}
+	for i := 0; i+1 < len(strs); i += 2 {
+		if strs[i] == uint32(kidx) {
+			return b.strings[strs[i+1]], true
+		}
+	}
+	return "", false
+}
+
+func (b Block) forEachTag(strs []uint32, iter func(key, value string) bool) {
+	for i := 0; i+1 < len(strs); i += 2 {
+		if !iter(b.strings[strs[i]], b.strings[strs[i+1]]) {
+			return
+		}
+	}
+}
+
+// TagCount returns the number of key/value tags.
+func (n Node) TagCount() int {
+	return n.NumStrings() / 2
+}
+
+// TagAt returns the key and value of the tag at index.
+func (n Node) TagAt(index int) (key, value string) {
+	return n.block.tagAt(n.block.nodeStrings[n.sset:n.send], index)
+}
+
+// Tag returns the value for the provided key.
+func (n Node) Tag(key string) (value string, ok bool) {
+	return n.block.tag(n.block.nodeStrings[n.sset:n.send], key)
+}
+
+// ForEachTag iterates over each tag. Return false from iter to stop.
+func (n Node) ForEachTag(iter func(key, value string) bool) {
+	n.block.forEachTag(n.block.nodeStrings[n.sset:n.send], iter)
+}
+
+// TagCount returns the number of key/value tags.
+func (w Way) TagCount() int {
+	return w.NumStrings() / 2
+}
+
+// TagAt returns the key and value of the tag at index.
+func (w Way) TagAt(index int) (key, value string) {
+	return w.block.tagAt(w.block.wayStrings[w.sset:w.send], index)
+}
+
+// Tag returns the value for the provided key.
+func (w Way) Tag(key string) (value string, ok bool) {
+	return w.block.tag(w.block.wayStrings[w.sset:w.send], key)
+}
+
+// ForEachTag iterates over each tag. Return false from iter to stop.
+func (w Way) ForEachTag(iter func(key, value string) bool) {
+	w.block.forEachTag(w.block.wayStrings[w.sset:w.send], iter)
+}
+
+// TagCount returns the number of key/value tags.
+func (r Relation) TagCount() int {
+	return r.NumStrings() / 2
+}
+
+// TagAt returns the key and value of the tag at index.
+func (r Relation) TagAt(index int) (key, value string) {
+	return r.block.tagAt(r.block.relationStrings[r.sset:r.send], index)
+}
+
+// Tag returns the value for the provided key.
+func (r Relation) Tag(key string) (value string, ok bool) {
+	return r.block.tag(r.block.relationStrings[r.sset:r.send], key)
+}
+
+// ForEachTag iterates over each tag. Return false from iter to stop.
+func (r Relation) ForEachTag(iter func(key, value string) bool) {
+	r.block.forEachTag(r.block.relationStrings[r.sset:r.send], iter)
+}
@@ -0,0 +1,521 @@
+// Copyright 2021 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package osmfile
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"time"
+
+	"github.com/tidwall/osmfile/internal/pbf"
+)
+
+// NodeValue is a node that can be written. Both Node and user defined types
+// may be used.
+type NodeValue interface {
+	ID() int64
+	Lat() float64
+	Lon() float64
+	NumStrings() int
+	StringAt(index int) string
+}
+
+// WayValue is a way that can be written. Both Way and user defined types
+// may be used.
+type WayValue interface {
+	ID() int64
+	NumRefs() int
+	RefAt(index int) int64
+	NumStrings() int
+	StringAt(index int) string
+}
+
+// RelationValue is a relation that can be written. Both Relation and user
+// defined types may be used.
+type RelationValue interface {
+	ID() int64
+	NumMembers() int
+	MemberAt(index int) (typ byte, ref int64, role string)
+	NumStrings() int
+	StringAt(index int) string
+}
+
+// MetadataValue may optionally be implemented by a NodeValue, WayValue, or
+// RelationValue to have its metadata written. Values with a zero Version and
+// Timestamp are treated as having no metadata.
+type MetadataValue interface {
+	Version() int
+	Timestamp() time.Time
+	Changeset() int64
+	UID() int
+	User() string
+	Visible() bool
+}
+
+// LocationsValue may optionally be implemented by a WayValue to have its node
+// locations written. Locations are only written when the header has the
+// "LocationsOnWays" optional feature.
+type LocationsValue interface {
+	HasLocations() bool
+	LatAt(index int) float64
+	LonAt(index int) float64
+}
+
+// maxBlockEntities is the maximum number of nodes, ways, or relations that
+// are written to a single block.
+const maxBlockEntities = 8000
+
+var errWriterHeader = errors.New("header must be written first")
+
+// Writer writes an OSM protobuf file.
+// Nodes, ways, and relations are grouped into blocks, and should be written
+// in that order, sorted by id, to produce a "Sort.Type_then_ID" file.
+type Writer struct {
+	w           io.Writer
+	err         error
+	wroteHeader bool
+	locsOnWays  bool // write way locations
+	history     bool // write the visible flag
+
+	// current block
+	kind     DataKind // kind of buffered entities, -1 for none
+	count    int      // number of buffered entities
+	strs     map[string]uint32
+	strtab   [][]byte
+	group    []byte // ways and relations
+	nodeIDs  []int64
+	nodeLats []int64
+	nodeLons []int64
+	nodeKVs  []uint64
+	nodeMeta bool
+	nodeInfo denseInfo
+}
+
+type denseInfo struct {
+	versions   []uint64
+	timestamps []int64
+	changesets []int64
+	uids       []int64
+	userSids   []int64
+	visibles   []uint64
+}
+
+// NewWriter returns a writer for writing an OSM protobuf file.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w, kind: -1}
+}
+
+// WriteHeader writes the OSMHeader block. It must be called before writing
+// any nodes, ways, or relations. If it's not called, then a default header
+// is written. The "OsmSchema-V0.6" and "DenseNodes" required features are
+// always included.
+func (w *Writer) WriteHeader(hdr Header) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.wroteHeader {
+		return errWriterHeader
+	}
+	w.wroteHeader = true
+	w.locsOnWays = hdr.HasFeature("LocationsOnWays")
+	w.history = hdr.HasFeature("HistoricalInformation")
+	required := []string{"OsmSchema-V0.6", "DenseNodes"}
+	for _, f := range hdr.RequiredFeatures {
+		if f != "OsmSchema-V0.6" && f != "DenseNodes" {
+			required = append(required, f)
+		}
+	}
+	hdr.RequiredFeatures = required
+	if hdr.WritingProgram == "" {
+		hdr.WritingProgram = "osmfile"
+	}
+	w.err = w.writeBlob("OSMHeader", appendHeader(nil, hdr))
+	return w.err
+}
+
+func appendHeader(dst []byte, hdr Header) []byte {
+	if hdr.BBox != nil {
+		var bbox []byte
+		bbox = pbf.AppendInt64(bbox, 1, nanodegrees(hdr.BBox.MinLon))
+		bbox = pbf.AppendInt64(bbox, 2, nanodegrees(hdr.BBox.MaxLon))
+		bbox = pbf.AppendInt64(bbox, 3, nanodegrees(hdr.BBox.MaxLat))
+		bbox = pbf.AppendInt64(bbox, 4, nanodegrees(hdr.BBox.MinLat))
+		dst = pbf.AppendBytes(dst, 1, bbox)
+	}
+	for _, f := range hdr.RequiredFeatures {
+		dst = pbf.AppendString(dst, 4, f)
+	}
+	for _, f := range hdr.OptionalFeatures {
+		dst = pbf.AppendString(dst, 5, f)
+	}
+	if hdr.WritingProgram != "" {
+		dst = pbf.AppendString(dst, 16, hdr.WritingProgram)
+	}
+	if hdr.Source != "" {
+		dst = pbf.AppendString(dst, 17, hdr.Source)
+	}
+	if !hdr.ReplicationTimestamp.IsZero() {
+		dst = pbf.AppendUint64(dst, 32,
+			uint64(hdr.ReplicationTimestamp.Unix()))
+	}
+	if hdr.ReplicationSequenceNumber != 0 {
+		dst = pbf.AppendUint64(dst, 33,
+			uint64(hdr.ReplicationSequenceNumber))
+	}
+	if hdr.ReplicationBaseURL != "" {
+		dst = pbf.AppendString(dst, 34, hdr.ReplicationBaseURL)
+	}
+	return dst
+}
+
+func nanodegrees(deg float64) int64 {
+	return int64(math.Round(deg * 1e9))
+}
+
+// begin prepares the writer for an entity of the provided kind, flushing the
+// current block when needed.
+func (w *Writer) begin(kind DataKind) error {
+	if w.err != nil {
+		return w.err
+	}
+	if !w.wroteHeader {
+		if err := w.WriteHeader(Header{}); err != nil {
+			return err
+		}
+	}
+	if w.kind != kind || w.count == maxBlockEntities {
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		w.kind = kind
+	}
+	w.count++
+	return nil
+}
+
+// str returns the string table index for the provided string.
+func (w *Writer) str(s string) uint32 {
+	if w.strs == nil {
+		w.strs = make(map[string]uint32)
+		w.strtab = append(w.strtab[:0], nil)
+		w.strs[""] = 0
+	}
+	idx, ok := w.strs[s]
+	if !ok {
+		idx = uint32(len(w.strtab))
+		w.strs[s] = idx
+		w.strtab = append(w.strtab, []byte(s))
+	}
+	return idx
+}
+
+// unixTime returns the unix time in seconds, or zero for the zero time.
+func unixTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func metadataOf(v interface{}) (MetadataValue, bool) {
+	if m, ok := v.(MetadataValue); ok {
+		if m.Version() != 0 || !m.Timestamp().IsZero() {
+			return m, true
+		}
+	}
+	return nil, false
+}
+
+// WriteNode writes a node. Tags with an empty key are skipped.
+func (w *Writer) WriteNode(n NodeValue) error {
+	if err := w.begin(DataKindNodes); err != nil {
+		return err
+	}
+	w.nodeIDs = append(w.nodeIDs, n.ID())
+	w.nodeLats = append(w.nodeLats, int64(math.Round(n.Lat()*1e7)))
+	w.nodeLons = append(w.nodeLons, int64(math.Round(n.Lon()*1e7)))
+	for i := 0; i+1 < n.NumStrings(); i += 2 {
+		key := n.StringAt(i)
+		if key == "" {
+			// index zero marks the end of the tags of a dense node, so a
+			// tag with an empty key cannot be written.
+			continue
+		}
+		w.nodeKVs = append(w.nodeKVs,
+			uint64(w.str(key)), uint64(w.str(n.StringAt(i+1))))
+	}
+	w.nodeKVs = append(w.nodeKVs, 0)
+	m, ok := metadataOf(n)
+	if ok && !w.nodeMeta {
+		// fill in the nodes that came before this one
+		w.nodeMeta = true
+		for i := 0; i < len(w.nodeIDs)-1; i++ {
+			w.nodeInfo.append(0, 0, 0, 0, 0, true)
+		}
+	}
+	if w.nodeMeta {
+		if ok {
+			w.nodeInfo.append(m.Version(), unixTime(m.Timestamp()),
+				m.Changeset(), m.UID(), w.str(m.User()), m.Visible())
+		} else {
+			w.nodeInfo.append(0, 0, 0, 0, 0, true)
+		}
+	}
+	return nil
+}
+
+func (d *denseInfo) append(version int, timestamp int64, changeset int64,
+	uid int, userSid uint32, visible bool,
+) {
+	d.versions = append(d.versions, uint64(version))
+	d.timestamps = append(d.timestamps, timestamp)
+	d.changesets = append(d.changesets, changeset)
+	d.uids = append(d.uids, int64(uid))
+	d.userSids = append(d.userSids, int64(userSid))
+	if visible {
+		d.visibles = append(d.visibles, 1)
+	} else {
+		d.visibles = append(d.visibles, 0)
+	}
+}
+
+func (d *denseInfo) reset() {
+	d.versions = d.versions[:0]
+	d.timestamps = d.timestamps[:0]
+	d.changesets = d.changesets[:0]
+	d.uids = d.uids[:0]
+	d.userSids = d.userSids[:0]
+	d.visibles = d.visibles[:0]
+}
+
+func (w *Writer) appendInfo(dst []byte, v interface{}) []byte {
+	m, ok := metadataOf(v)
+	if !ok {
+		return dst
+	}
+	var info []byte
+	info = pbf.AppendUint64(info, 1, uint64(m.Version()))
+	info = pbf.AppendUint64(info, 2, uint64(unixTime(m.Timestamp())))
+	info = pbf.AppendUint64(info, 3, uint64(m.Changeset()))
+	info = pbf.AppendUint64(info, 4, uint64(m.UID()))
+	info = pbf.AppendUint64(info, 5, uint64(w.str(m.User())))
+	if w.history {
+		var visible uint64
+		if m.Visible() {
+			visible = 1
+		}
+		info = pbf.AppendUint64(info, 6, visible)
+	}
+	return pbf.AppendBytes(dst, 4, info)
+}
+
+func (w *Writer) appendTags(dst []byte, numStrings int,
+	stringAt func(index int) string,
+) []byte {
+	keys := make([]uint64, 0, numStrings/2)
+	vals := make([]uint64, 0, numStrings/2)
+	for i := 0; i+1 < numStrings; i += 2 {
+		keys = append(keys, uint64(w.str(stringAt(i))))
+		vals = append(vals, uint64(w.str(stringAt(i+1))))
+	}
+	dst = pbf.AppendPackedUint64(dst, 2, keys)
+	dst = pbf.AppendPackedUint64(dst, 3, vals)
+	return dst
+}
+
+// WriteWay writes a way.
+func (w *Writer) WriteWay(way WayValue) error {
+	if err := w.begin(DataKindWays); err != nil {
+		return err
+	}
+	var msg []byte
+	msg = pbf.AppendUint64(msg, 1, uint64(way.ID()))
+	msg = w.appendTags(msg, way.NumStrings(), way.StringAt)
+	msg = w.appendInfo(msg, way)
+	n := way.NumRefs()
+	refs := make([]int64, n)
+	var prev int64
+	for i := 0; i < n; i++ {
+		ref := way.RefAt(i)
+		refs[i] = ref - prev
+		prev = ref
+	}
+	msg = pbf.AppendPackedInt64(msg, 8, refs)
+	if locs, ok := way.(LocationsValue); ok && w.locsOnWays &&
+		locs.HasLocations() {
+		lats := make([]int64, n)
+		lons := make([]int64, n)
+		var prevLat, prevLon int64
+		for i := 0; i < n; i++ {
+			lat := int64(math.Round(locs.LatAt(i) * 1e7))
+			lon := int64(math.Round(locs.LonAt(i) * 1e7))
+			lats[i], lons[i] = lat-prevLat, lon-prevLon
+			prevLat, prevLon = lat, lon
+		}
+		msg = pbf.AppendPackedInt64(msg, 9, lats)
+		msg = pbf.AppendPackedInt64(msg, 10, lons)
+	}
+	w.group = pbf.AppendBytes(w.group, 3, msg)
+	return nil
+}
+
+// WriteRelation writes a relation.
+func (w *Writer) WriteRelation(rel RelationValue) error {
+	if err := w.begin(DataKindRelations); err != nil {
+		return err
+	}
+	var msg []byte
+	msg = pbf.AppendUint64(msg, 1, uint64(rel.ID()))
+	msg = w.appendTags(msg, rel.NumStrings(), rel.StringAt)
+	msg = w.appendInfo(msg, rel)
+	n := rel.NumMembers()
+	roles := make([]uint64, n)
+	refs := make([]int64, n)
+	types := make([]uint64, n)
+	var prev int64
+	for i := 0; i < n; i++ {
+		typ, ref, role := rel.MemberAt(i)
+		roles[i] = uint64(w.str(role))
+		refs[i] = ref - prev
+		prev = ref
+		types[i] = uint64(typ)
+	}
+	msg = pbf.AppendPackedUint64(msg, 8, roles)
+	msg = pbf.AppendPackedInt64(msg, 9, refs)
+	msg = pbf.AppendPackedUint64(msg, 10, types)
+	w.group = pbf.AppendBytes(w.group, 4, msg)
+	return nil
+}
+
+// Flush writes all buffered nodes, ways, and relations as a block. The header
+// is written if it hasn't been yet.
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	if !w.wroteHeader {
+		if err := w.WriteHeader(Header{}); err != nil {
+			return err
+		}
+	}
+	if w.count == 0 {
+		return nil
+	}
+	var group []byte
+	if w.kind == DataKindNodes {
+		group = pbf.AppendBytes(nil, 2, w.appendDenseNodes(nil))
+	} else {
+		group = w.group
+	}
+	var strtab []byte
+	for _, s := range w.strtab {
+		strtab = pbf.AppendBytes(strtab, 1, s)
+	}
+	var block []byte
+	block = pbf.AppendBytes(block, 1, strtab)
+	block = pbf.AppendBytes(block, 2, group)
+	w.err = w.writeBlob("OSMData", block)
+
+	// reset the block
+	w.kind = -1
+	w.count = 0
+	w.strs = nil
+	w.strtab = w.strtab[:0]
+	w.group = w.group[:0]
+	w.nodeIDs = w.nodeIDs[:0]
+	w.nodeLats = w.nodeLats[:0]
+	w.nodeLons = w.nodeLons[:0]
+	w.nodeKVs = w.nodeKVs[:0]
+	w.nodeMeta = false
+	w.nodeInfo.reset()
+	return w.err
+}
+
+func deltas(xs []int64) []int64 {
+	out := make([]int64, len(xs))
+	var prev int64
+	for i, x := range xs {
+		out[i] = x - prev
+		prev = x
+	}
+	return out
+}
+
+func (w *Writer) appendDenseNodes(dst []byte) []byte {
+	//
+	// message DenseNodes {
+	// 	repeated sint64 id = 1 [packed = true]; // DELTA coded
+	// 	optional DenseInfo denseinfo = 5;
+	// 	repeated sint64 lat = 8 [packed = true]; // DELTA coded
+	// 	repeated sint64 lon = 9 [packed = true]; // DELTA coded
+	// 	repeated int32 keys_vals = 10 [packed = true];
+	// }
+	//
+	dst = pbf.AppendPackedInt64(dst, 1, deltas(w.nodeIDs))
+	if w.nodeMeta {
+		var info []byte
+		info = pbf.AppendPackedUint64(info, 1, w.nodeInfo.versions)
+		info = pbf.AppendPackedInt64(info, 2, deltas(w.nodeInfo.timestamps))
+		info = pbf.AppendPackedInt64(info, 3, deltas(w.nodeInfo.changesets))
+		info = pbf.AppendPackedInt64(info, 4, deltas(w.nodeInfo.uids))
+		info = pbf.AppendPackedInt64(info, 5, deltas(w.nodeInfo.userSids))
+		if w.history {
+			info = pbf.AppendPackedUint64(info, 6, w.nodeInfo.visibles)
+		}
+		dst = pbf.AppendBytes(dst, 5, info)
+	}
+	dst = pbf.AppendPackedInt64(dst, 8, deltas(w.nodeLats))
+	dst = pbf.AppendPackedInt64(dst, 9, deltas(w.nodeLons))
+	var tagged bool
+	for _, kv := range w.nodeKVs {
+		if kv != 0 {
+			tagged = true
+			break
+		}
+	}
+	if tagged {
+		dst = pbf.AppendPackedUint64(dst, 10, w.nodeKVs)
+	}
+	return dst
+}
+
+func (w *Writer) writeBlob(typ string, data []byte) error {
+	//
+	// message Blob {
+	// 	optional int32 raw_size = 2;
+	// 	optional bytes zlib_data = 3;
+	// }
+	//
+	var zdata bytes.Buffer
+	zw := zlib.NewWriter(&zdata)
+	if _, err := zw.Write(data); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	var blob []byte
+	blob = pbf.AppendUint64(blob, 2, uint64(len(data)))
+	blob = pbf.AppendBytes(blob, 3, zdata.Bytes())
+	//
+	// message BlobHeader {
+	// 	required string type = 1;
+	// 	required int32 datasize = 3;
+	// }
+	//
+	var hdr []byte
+	hdr = pbf.AppendString(hdr, 1, typ)
+	hdr = pbf.AppendUint64(hdr, 3, uint64(len(blob)))
+	buf := make([]byte, 4, 4+len(hdr)+len(blob))
+	binary.BigEndian.PutUint32(buf, uint32(len(hdr)))
+	buf = append(buf, hdr...)
+	buf = append(buf, blob...)
+	_, err := w.w.Write(buf)
+	return err
+}
@@ -0,0 +1,279 @@
+// Copyright 2021 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package osmfile
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"testing"
+	"time"
+)
+
+type testMeta struct {
+	version   int
+	timestamp time.Time
+	changeset int64
+	uid       int
+	user      string
+}
+
+func (m testMeta) Version() int         { return m.version }
+func (m testMeta) Timestamp() time.Time { return m.timestamp }
+func (m testMeta) Changeset() int64     { return m.changeset }
+func (m testMeta) UID() int             { return m.uid }
+func (m testMeta) User() string         { return m.user }
+func (m testMeta) Visible() bool        { return true }
+
+type testNode struct {
+	testMeta
+	id       int64
+	lat, lon float64
+	tags     []string
+}
+
+func (n testNode) ID() int64                 { return n.id }
+func (n testNode) Lat() float64              { return n.lat }
+func (n testNode) Lon() float64              { return n.lon }
+func (n testNode) NumStrings() int           { return len(n.tags) }
+func (n testNode) StringAt(index int) string { return n.tags[index] }
+
+type testWay struct {
+	testMeta
+	id   int64
+	refs []int64
+	tags []string
+}
+
+func (w testWay) ID() int64                 { return w.id }
+func (w testWay) NumRefs() int              { return len(w.refs) }
+func (w testWay) RefAt(index int) int64     { return w.refs[index] }
+func (w testWay) NumStrings() int           { return len(w.tags) }
+func (w testWay) StringAt(index int) string { return w.tags[index] }
+func (w testWay) HasLocations() bool        { return true }
+func (w testWay) LatAt(index int) float64   { return testLat(w.refs[index]) }
+func (w testWay) LonAt(index int) float64   { return testLon(w.refs[index]) }
+
+type testRelation struct {
+	testMeta
+	id      int64
+	members []int64
+	tags    []string
+}
+
+func (r testRelation) ID() int64                 { return r.id }
+func (r testRelation) NumMembers() int           { return len(r.members) }
+func (r testRelation) NumStrings() int           { return len(r.tags) }
+func (r testRelation) StringAt(index int) string { return r.tags[index] }
+func (r testRelation) MemberAt(index int) (typ byte, ref int64, role string) {
+	return 1, r.members[index], fmt.Sprintf("role%d", index)
+}
+
+func testLat(id int64) float64 { return float64(id%1800)/10 - 90 }
+func testLon(id int64) float64 { return float64(id%3600)/10 - 180 }
+
+// testMetaFor returns the metadata of an element, which is left out for the
+// first elements of each kind, so that it starts in the middle of a block.
+func testMetaFor(id int64) testMeta {
+	if id%10000 < 100 {
+		return testMeta{}
+	}
+	return testMeta{
+		version:   int(id % 7),
+		timestamp: time.Unix(1600000000+id, 0),
+		changeset: id * 3,
+		uid:       int(id % 11),
+		user:      fmt.Sprintf("user%d", id%5),
+	}
+}
+
+const testNumNodes = 8100
+
+// writeTestFile writes a file with nodes, ways, and relations. There are more
+// nodes than fit in a single block.
+func writeTestFile(t *testing.T, hdr Header) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	if err := w.WriteHeader(hdr); err != nil {
+		t.Fatal(err)
+	}
+	for id := int64(1); id <= testNumNodes; id++ {
+		n := testNode{testMeta: testMetaFor(id), id: id,
+			lat: testLat(id), lon: testLon(id)}
+		if id%3 == 0 {
+			n.tags = []string{"name", fmt.Sprintf("node%d", id), "a", "b"}
+		}
+		if err := w.WriteNode(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for id := int64(10001); id <= 10200; id++ {
+		way := testWay{testMeta: testMetaFor(id), id: id,
+			refs: []int64{id - 10000, id - 9999, id - 9998},
+			tags: []string{"highway", "residential"}}
+		if err := w.WriteWay(way); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for id := int64(20001); id <= 20200; id++ {
+		rel := testRelation{testMeta: testMetaFor(id), id: id,
+			members: []int64{id - 10000, id - 9999},
+			tags:    []string{"type", "multipolygon"}}
+		if err := w.WriteRelation(rel); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func testCheckMeta(t *testing.T, id int64, m MetadataValue) {
+	t.Helper()
+	want := testMetaFor(id)
+	if m.Version() != want.version ||
+		!m.Timestamp().Equal(want.timestamp) ||
+		m.Changeset() != want.changeset || m.UID() != want.uid ||
+		m.User() != want.user {
+		t.Fatalf("%d: expected metadata %v, got %d %v %d %d %q", id, want,
+			m.Version(), m.Timestamp(), m.Changeset(), m.UID(), m.User())
+	}
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	data := writeTestFile(t, Header{
+		OptionalFeatures: []string{"Sort.Type_then_ID", "LocationsOnWays"},
+	})
+	br := NewBlockReader(bytes.NewReader(data))
+	hdr, err := br.Header()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hdr.HasFeature("DenseNodes") || !hdr.HasFeature("LocationsOnWays") {
+		t.Fatalf("missing features: %v %v", hdr.RequiredFeatures,
+			hdr.OptionalFeatures)
+	}
+	var blocks, nodes, ways, rels int
+	for {
+		_, block, err := br.ReadBlockWhat(Everything | Metadata)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks++
+		for i := 0; i < block.NumNodes(); i++ {
+			n := block.NodeAt(i)
+			nodes++
+			if n.ID() != int64(nodes) {
+				t.Fatalf("expected node %d, got %d", nodes, n.ID())
+			}
+			if math.Abs(n.Lat()-testLat(n.ID())) > 1e-7 ||
+				math.Abs(n.Lon()-testLon(n.ID())) > 1e-7 {
+				t.Fatalf("%d: wrong location %v %v", n.ID(), n.Lat(),
+					n.Lon())
+			}
+			if n.ID()%3 == 0 {
+				name, ok := n.Tag("name")
+				if n.TagCount() != 2 || !ok ||
+					name != fmt.Sprintf("node%d", n.ID()) {
+					t.Fatalf("%d: wrong tags", n.ID())
+				}
+			} else if n.TagCount() != 0 {
+				t.Fatalf("%d: expected no tags, got %d", n.ID(),
+					n.TagCount())
+			}
+			testCheckMeta(t, n.ID(), n)
+		}
+		for i := 0; i < block.NumWays(); i++ {
+			way := block.WayAt(i)
+			ways++
+			if way.NumRefs() != 3 || way.RefAt(2) != way.ID()-9998 {
+				t.Fatalf("%d: wrong refs", way.ID())
+			}
+			if !way.HasLocations() {
+				t.Fatalf("%d: expected locations", way.ID())
+			}
+			for j := 0; j < way.NumRefs(); j++ {
+				ref := way.RefAt(j)
+				if math.Abs(way.LatAt(j)-testLat(ref)) > 1e-7 ||
+					math.Abs(way.LonAt(j)-testLon(ref)) > 1e-7 {
+					t.Fatalf("%d: wrong location of %d", way.ID(), ref)
+				}
+			}
+			if v, _ := way.Tag("highway"); v != "residential" {
+				t.Fatalf("%d: wrong tags", way.ID())
+			}
+			testCheckMeta(t, way.ID(), way)
+		}
+		for i := 0; i < block.NumRelations(); i++ {
+			rel := block.RelationAt(i)
+			rels++
+			if rel.NumMembers() != 2 {
+				t.Fatalf("%d: wrong members", rel.ID())
+			}
+			typ, ref, role := rel.MemberAt(1)
+			if typ != 1 || ref != rel.ID()-9999 || role != "role1" {
+				t.Fatalf("%d: wrong member %d %d %q", rel.ID(), typ, ref,
+					role)
+			}
+			testCheckMeta(t, rel.ID(), rel)
+		}
+	}
+	if nodes != testNumNodes || ways != 200 || rels != 200 {
+		t.Fatalf("expected %d/200/200 elements, got %d/%d/%d",
+			testNumNodes, nodes, ways, rels)
+	}
+	// the nodes are split into two blocks
+	if blocks != 4 {
+		t.Fatalf("expected 4 blocks, got %d", blocks)
+	}
+}
+
+func TestWriterNoLocations(t *testing.T) {
+	data := writeTestFile(t, Header{})
+	br := NewBlockReader(bytes.NewReader(data))
+	for {
+		_, block, err := br.ReadBlockWhat(Ways)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < block.NumWays(); i++ {
+			if block.WayAt(i).HasLocations() {
+				t.Fatal("expected no locations without the feature")
+			}
+		}
+	}
+}
+
+func TestWriterEmptyKey(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.WriteNode(testNode{id: 1, tags: []string{"", "x", "a", "b"}})
+	w.WriteNode(testNode{id: 2, tags: []string{"c", "d"}})
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	_, block, err := NewBlockReader(&buf).ReadBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.NumNodes() != 2 {
+		t.Fatalf("expected 2 nodes, got %d", block.NumNodes())
+	}
+	for i, want := range []string{"a", "c"} {
+		n := block.NodeAt(i)
+		if k, _ := n.TagAt(0); n.TagCount() != 1 || k != want {
+			t.Fatalf("%d: expected tag %q", n.ID(), want)
+		}
+	}
+}
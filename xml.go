@@ -0,0 +1,267 @@
+// Copyright 2021 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package osmfile
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+type xmlTag struct {
+	K string `xml:"k,attr"`
+	V string `xml:"v,attr"`
+}
+
+type xmlNd struct {
+	Ref int64 `xml:"ref,attr"`
+}
+
+type xmlMember struct {
+	Type string `xml:"type,attr"`
+	Ref  int64  `xml:"ref,attr"`
+	Role string `xml:"role,attr"`
+}
+
+// xmlElement is a node, way, or relation element.
+type xmlElement struct {
+	XMLName   xml.Name
+	ID        int64       `xml:"id,attr"`
+	Lat       float64     `xml:"lat,attr"`
+	Lon       float64     `xml:"lon,attr"`
+	Version   int32       `xml:"version,attr"`
+	Timestamp string      `xml:"timestamp,attr"`
+	Changeset int64       `xml:"changeset,attr"`
+	UID       int32       `xml:"uid,attr"`
+	User      string      `xml:"user,attr"`
+	Visible   string      `xml:"visible,attr"`
+	Tags      []xmlTag    `xml:"tag"`
+	Nds       []xmlNd     `xml:"nd"`
+	Members   []xmlMember `xml:"member"`
+}
+
+func (el *xmlElement) kind() DataKind {
+	switch el.XMLName.Local {
+	case "node":
+		return DataKindNodes
+	case "way":
+		return DataKindWays
+	default:
+		return DataKindRelations
+	}
+}
+
+func memberType(s string) (byte, error) {
+	switch s {
+	case "node":
+		return 0, nil
+	case "way":
+		return 1, nil
+	case "relation":
+		return 2, nil
+	}
+	return 0, fmt.Errorf("invalid member type: %q", s)
+}
+
+// add adds the element to the block builder.
+func (el *xmlElement) add(bb *blockBuilder) error {
+	info := blockInfo{
+		version:   el.Version,
+		changeset: el.Changeset,
+		uid:       el.UID,
+		userSid:   bb.str(el.User),
+		visible:   el.Visible != "false",
+	}
+	if el.Timestamp != "" {
+		ts, err := time.Parse(time.RFC3339, el.Timestamp)
+		if err != nil {
+			return err
+		}
+		info.timestamp = ts.Unix()
+	}
+	tags := make([]string, 0, len(el.Tags)*2)
+	for _, tag := range el.Tags {
+		tags = append(tags, tag.K, tag.V)
+	}
+	switch el.kind() {
+	case DataKindNodes:
+		bb.addNode(el.ID, el.Lat, el.Lon, tags, info)
+	case DataKindWays:
+		refs := make([]int64, len(el.Nds))
+		for i, nd := range el.Nds {
+			refs[i] = nd.Ref
+		}
+		bb.addWay(el.ID, refs, tags, info)
+	case DataKindRelations:
+		types := make([]byte, len(el.Members))
+		refs := make([]int64, len(el.Members))
+		roles := make([]string, len(el.Members))
+		for i, m := range el.Members {
+			typ, err := memberType(m.Type)
+			if err != nil {
+				return err
+			}
+			types[i], refs[i], roles[i] = typ, m.Ref, m.Role
+		}
+		bb.addRelation(el.ID, types, refs, roles, tags, info)
+	}
+	return nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// decompress returns a reader that decompresses bzip2 and gzip data, and
+// passes through everything else.
+func decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(3)
+	switch {
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		return bzip2.NewReader(br), nil
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return gzip.NewReader(br)
+	}
+	return br, nil
+}
+
+// XMLReader is a reader for reading blocks from an OSM XML (.osm) file. The
+// blocks have the same accessors as the ones read from a protobuf file, and
+// always include the metadata. Like protobuf files, each block only contains
+// one kind of data.
+type XMLReader struct {
+	cr      *countingReader
+	dec     *xml.Decoder
+	err     error
+	bb      *blockBuilder
+	read    int64       // bytes returned in previous blocks
+	pending *xmlElement // element that belongs to the next block
+	header  *Header
+}
+
+// NewXMLReader returns a reader for reading blocks from an OSM XML file.
+// Files compressed using bzip2 (.osm.bz2) or gzip (.osm.gz) are detected and
+// decompressed.
+func NewXMLReader(r io.Reader) *XMLReader {
+	xr := &XMLReader{cr: &countingReader{r: r}, bb: newBlockBuilder()}
+	dr, err := decompress(xr.cr)
+	if err != nil {
+		xr.err = err
+	} else {
+		xr.dec = xml.NewDecoder(dr)
+	}
+	return xr
+}
+
+// next returns the next node, way, or relation element.
+func (r *XMLReader) next() (*xmlElement, error) {
+	if r.pending != nil {
+		el := r.pending
+		r.pending = nil
+		return el, nil
+	}
+	for {
+		tok, err := r.dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "osm":
+			if r.header == nil {
+				r.header = &Header{}
+			}
+			for _, attr := range start.Attr {
+				if attr.Name.Local == "generator" {
+					r.header.WritingProgram = attr.Value
+				}
+			}
+		case "bounds":
+			var b struct {
+				MinLat float64 `xml:"minlat,attr"`
+				MinLon float64 `xml:"minlon,attr"`
+				MaxLat float64 `xml:"maxlat,attr"`
+				MaxLon float64 `xml:"maxlon,attr"`
+			}
+			if err := r.dec.DecodeElement(&b, &start); err != nil {
+				return nil, err
+			}
+			if r.header == nil {
+				r.header = &Header{}
+			}
+			r.header.BBox = &BBox{b.MinLat, b.MinLon, b.MaxLat, b.MaxLon}
+		case "node", "way", "relation":
+			el := new(xmlElement)
+			if err := r.dec.DecodeElement(el, &start); err != nil {
+				return nil, err
+			}
+			return el, nil
+		}
+	}
+}
+
+// Header returns a header with the generator and bounds of the file.
+func (r *XMLReader) Header() (Header, error) {
+	if r.err != nil {
+		return Header{}, r.err
+	}
+	if r.pending == nil && r.bb.count == 0 {
+		el, err := r.next()
+		if err != nil && err != io.EOF {
+			r.err = err
+			return Header{}, err
+		}
+		r.pending = el
+	}
+	if r.header == nil {
+		return Header{}, ErrNoHeader
+	}
+	return *r.header, nil
+}
+
+// ReadBlock reads the next block.
+// Returns the number of bytes read and the block.
+func (r *XMLReader) ReadBlock() (n int, block Block, err error) {
+	if r.err != nil {
+		return 0, Block{}, r.err
+	}
+	for r.bb.count < maxBlockEntities {
+		el, err := r.next()
+		if err != nil {
+			if err == io.EOF && r.bb.count > 0 {
+				break
+			}
+			r.err = err
+			return 0, Block{}, err
+		}
+		if r.bb.count > 0 && DataKind(r.bb.block.dataKind) != el.kind() {
+			r.pending = el
+			break
+		}
+		if err := el.add(r.bb); err != nil {
+			r.err = err
+			return 0, Block{}, err
+		}
+	}
+	n = int(r.cr.n - r.read)
+	r.read = r.cr.n
+	return n, r.bb.finish(), nil
+}
@@ -0,0 +1,217 @@
+// Copyright 2021 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package osmfile
+
+import (
+	"bufio"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var errWriterClosed = errors.New("writer closed")
+
+// XMLWriter writes an OSM XML (.osm) file.
+type XMLWriter struct {
+	w           *bufio.Writer
+	err         error
+	wroteHeader bool
+	closed      bool
+}
+
+// NewXMLWriter returns a writer for writing an OSM XML file. The writer must
+// be closed to complete the file.
+func NewXMLWriter(w io.Writer) *XMLWriter {
+	return &XMLWriter{w: bufio.NewWriter(w)}
+}
+
+func (w *XMLWriter) attr(name, value string) {
+	w.w.WriteByte(' ')
+	w.w.WriteString(name)
+	w.w.WriteString(`="`)
+	xml.EscapeText(w.w, []byte(value))
+	w.w.WriteByte('"')
+}
+
+func (w *XMLWriter) attrInt(name string, x int64) {
+	w.attr(name, strconv.FormatInt(x, 10))
+}
+
+func (w *XMLWriter) attrDegrees(name string, x float64) {
+	s := strconv.FormatFloat(x, 'f', 7, 64)
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	w.attr(name, s)
+}
+
+// WriteHeader writes the root element, using the writing program and bbox
+// of the header. It must be called before writing any nodes, ways, or
+// relations. If it's not called, then a default header is written.
+func (w *XMLWriter) WriteHeader(hdr Header) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.wroteHeader {
+		return errWriterHeader
+	}
+	w.wroteHeader = true
+	if hdr.WritingProgram == "" {
+		hdr.WritingProgram = "osmfile"
+	}
+	w.w.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	w.w.WriteString(`<osm version="0.6"`)
+	w.attr("generator", hdr.WritingProgram)
+	w.w.WriteString(">\n")
+	if hdr.BBox != nil {
+		w.w.WriteString(" <bounds")
+		w.attrDegrees("minlat", hdr.BBox.MinLat)
+		w.attrDegrees("minlon", hdr.BBox.MinLon)
+		w.attrDegrees("maxlat", hdr.BBox.MaxLat)
+		w.attrDegrees("maxlon", hdr.BBox.MaxLon)
+		w.w.WriteString("/>\n")
+	}
+	return nil
+}
+
+func (w *XMLWriter) begin(name string, id int64, v interface{}) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.closed {
+		return errWriterClosed
+	}
+	if !w.wroteHeader {
+		if err := w.WriteHeader(Header{}); err != nil {
+			return err
+		}
+	}
+	w.w.WriteString(" <")
+	w.w.WriteString(name)
+	w.attrInt("id", id)
+	if m, ok := metadataOf(v); ok {
+		w.attrInt("version", int64(m.Version()))
+		if ts := m.Timestamp(); !ts.IsZero() {
+			w.attr("timestamp", ts.UTC().Format("2006-01-02T15:04:05Z"))
+		}
+		if m.Changeset() != 0 {
+			w.attrInt("changeset", m.Changeset())
+		}
+		if m.User() != "" {
+			w.attrInt("uid", int64(m.UID()))
+			w.attr("user", m.User())
+		}
+	}
+	if m, ok := v.(MetadataValue); ok && !m.Visible() {
+		w.attr("visible", "false")
+	}
+	return nil
+}
+
+// children writes the end of the start tag, followed by the tags, and the
+// end tag. The extra func writes additional child elements.
+func (w *XMLWriter) children(name string, numStrings int,
+	stringAt func(index int) string, extra int, writeExtra func(),
+) error {
+	if numStrings/2 == 0 && extra == 0 {
+		w.w.WriteString("/>\n")
+		return w.writeError()
+	}
+	w.w.WriteString(">\n")
+	if writeExtra != nil {
+		writeExtra()
+	}
+	for i := 0; i+1 < numStrings; i += 2 {
+		w.w.WriteString("  <tag")
+		w.attr("k", stringAt(i))
+		w.attr("v", stringAt(i+1))
+		w.w.WriteString("/>\n")
+	}
+	w.w.WriteString(" </")
+	w.w.WriteString(name)
+	w.w.WriteString(">\n")
+	return w.writeError()
+}
+
+// writeError returns the first error that occurred while writing.
+func (w *XMLWriter) writeError() error {
+	if w.err == nil {
+		// bufio.Writer errors are sticky, so an empty write reveals them
+		_, w.err = w.w.Write(nil)
+	}
+	return w.err
+}
+
+// WriteNode writes a node.
+func (w *XMLWriter) WriteNode(n NodeValue) error {
+	if err := w.begin("node", n.ID(), n); err != nil {
+		return err
+	}
+	w.attrDegrees("lat", n.Lat())
+	w.attrDegrees("lon", n.Lon())
+	return w.children("node", n.NumStrings(), n.StringAt, 0, nil)
+}
+
+// WriteWay writes a way.
+func (w *XMLWriter) WriteWay(way WayValue) error {
+	if err := w.begin("way", way.ID(), way); err != nil {
+		return err
+	}
+	return w.children("way", way.NumStrings(), way.StringAt, way.NumRefs(),
+		func() {
+			for i := 0; i < way.NumRefs(); i++ {
+				w.w.WriteString("  <nd")
+				w.attrInt("ref", way.RefAt(i))
+				w.w.WriteString("/>\n")
+			}
+		},
+	)
+}
+
+// WriteRelation writes a relation.
+func (w *XMLWriter) WriteRelation(rel RelationValue) error {
+	if err := w.begin("relation", rel.ID(), rel); err != nil {
+		return err
+	}
+	return w.children("relation", rel.NumStrings(), rel.StringAt,
+		rel.NumMembers(), func() {
+			for i := 0; i < rel.NumMembers(); i++ {
+				typ, ref, role := rel.MemberAt(i)
+				w.w.WriteString("  <member")
+				switch typ {
+				case 0:
+					w.attr("type", "node")
+				case 1:
+					w.attr("type", "way")
+				default:
+					w.attr("type", "relation")
+				}
+				w.attrInt("ref", ref)
+				w.attr("role", role)
+				w.w.WriteString("/>\n")
+			}
+		},
+	)
+}
+
+// Close writes the end of the file and flushes all buffered data. It does
+// not close the underlying writer.
+func (w *XMLWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.closed {
+		return errWriterClosed
+	}
+	if !w.wroteHeader {
+		if err := w.WriteHeader(Header{}); err != nil {
+			return err
+		}
+	}
+	w.closed = true
+	w.w.WriteString("</osm>\n")
+	w.err = w.w.Flush()
+	return w.err
+}